@@ -3,14 +3,15 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"strconv"
 
 	"github.com/spf13/cobra"
 
-	pbc "github.com/swinslow/peridot-core/pkg/controller"
 	"github.com/swinslow/peridotctl/internal/config"
+	"github.com/swinslow/peridotctl/pkg/client"
+	"github.com/swinslow/peridotctl/pkg/parser"
 )
 
 func init() {
@@ -29,7 +30,7 @@ func init() {
 		Short: "Get all registered agents",
 		Long: `Get information about all agents registered with the
 		peridot controller.`,
-		Run: agentList,
+		RunE: agentList,
 	}
 	cmdAgent.AddCommand(cmdAgentList)
 
@@ -46,7 +47,7 @@ Format: peridotctl agent add NAME URL PORT TYPE [CFGSTRING]
 	TYPE:      Agent instance type (may be repeated with other agents)
 	CFGSTRING: Optional: agent configuration values (in format key1:value1;key2:value2;...)`,
 		Args: cobra.RangeArgs(4, 5),
-		Run:  agentAdd,
+		RunE: agentAdd,
 	}
 	cmdAgent.AddCommand(cmdAgentAdd)
 
@@ -55,25 +56,26 @@ Format: peridotctl agent add NAME URL PORT TYPE [CFGSTRING]
 		Short: "Get info on registered agent",
 		Long: `Get information about an agent that has already been
 		registered with the peridot controller.`,
-		Args: cobra.ExactArgs(1),
-		Run:  agentGet,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeFirstArg("agents", lookupAgentNames),
+		RunE:              agentGet,
 	}
 	cmdAgent.AddCommand(cmdAgentGet)
 }
 
-func agentList(cmd *cobra.Command, args []string) {
+func agentList(cmd *cobra.Command, args []string) error {
 	ctx, cancel := config.GetContext(timeout)
 	defer cancel()
-	defer conn.Close()
+	defer closeConn()
 
-	resp, err := c.GetAllAgents(ctx, &pbc.GetAllAgentsReq{})
+	agentConfigs, err := cl.GetAllAgents(ctx)
 	if err != nil {
-		log.Fatalf("could not get agents: %v", err)
+		return failf("could not get agents: %v", err)
 	}
 
 	fmt.Printf("Registered agents:\n\n")
 
-	for _, agentConfig := range resp.Cfgs {
+	for _, agentConfig := range agentConfigs {
 		fmt.Printf("name: %s\n", agentConfig.Name)
 		fmt.Printf("url: %s\n", agentConfig.Url)
 		fmt.Printf("port: %d\n", agentConfig.Port)
@@ -85,12 +87,13 @@ func agentList(cmd *cobra.Command, args []string) {
 		fmt.Printf("\n")
 	}
 	fmt.Printf("\n")
+	return nil
 }
 
-func agentAdd(cmd *cobra.Command, args []string) {
+func agentAdd(cmd *cobra.Command, args []string) error {
 	ctx, cancel := config.GetContext(timeout)
 	defer cancel()
-	defer conn.Close()
+	defer closeConn()
 
 	name := args[0]
 	url := args[1]
@@ -103,71 +106,60 @@ func agentAdd(cmd *cobra.Command, args []string) {
 
 	portInt, err := strconv.Atoi(portStr)
 	if err != nil || portInt <= 0 {
-		log.Fatalf("invalid agent port: %s", portStr)
+		return usageErrorf("invalid agent port: %s", portStr)
 	}
 
 	// check whether values are okay
 	if name == "" {
-		log.Fatal("no agent name specified")
+		return usageErrorf("no agent name specified")
 	}
 	// URL defaulting to "localhost" is acceptable, but empty string isn't
 	if url == "" {
-		log.Fatal("agent URL cannot be empty string")
+		return usageErrorf("agent URL cannot be empty string")
 	}
 	if typeStr == "" {
-		log.Fatal("no agent type specified")
+		return usageErrorf("no agent type specified")
 	}
 
 	// extract configuration key-value pairs -- semicolons separating pairs,
 	// colons separating key from value within a pair
 	cfgs := config.ExtractKVs(cfgStr)
 
-	// build into AgentKV list
-	kvs := []*pbc.AgentConfig_AgentKV{}
-	for k, v := range cfgs {
-		kv := pbc.AgentConfig_AgentKV{Key: k, Value: v}
-		kvs = append(kvs, &kv)
-	}
-
-	// build AgentConfig object
-	ac := &pbc.AgentConfig{
-		Name: name,
-		Url:  url,
-		Port: uint32(portInt),
-		Type: typeStr,
-		Kvs:  kvs,
-	}
-
-	resp, err := c.AddAgent(ctx, &pbc.AddAgentReq{Cfg: ac})
-	if err != nil {
-		log.Fatalf("could not add agent: %v", err)
-	}
-
-	if resp.Success {
-		fmt.Printf("agent %s successfully registered\n", name)
-	} else {
-		fmt.Printf("error registering agent %s: %s\n", name, resp.ErrorMsg)
-	}
+	ac := client.BuildAgentConfig(parser.PeridotAgent{
+		Name:    name,
+		URL:     url,
+		Port:    uint32(portInt),
+		TypeStr: typeStr,
+		Configs: cfgs,
+	})
+
+	err = cl.AddAgent(ctx, ac)
+	var rerr *client.RejectedError
+	if errors.As(err, &rerr) {
+		return exitErrorf(exitRejected, "error registering agent %s: %s", name, rerr.Msg)
+	} else if err != nil {
+		return failf("could not add agent: %v", err)
+	}
+	fmt.Printf("agent %s successfully registered\n", name)
 	fmt.Printf("\n")
+	return nil
 }
 
-func agentGet(cmd *cobra.Command, args []string) {
+func agentGet(cmd *cobra.Command, args []string) error {
 	ctx, cancel := config.GetContext(timeout)
 	defer cancel()
-	defer conn.Close()
+	defer closeConn()
 
 	name := args[0]
 
-	resp, err := c.GetAgent(ctx, &pbc.GetAgentReq{Name: name})
-	if err != nil {
-		log.Fatalf("could not get agent %s: %v", name, err)
-	}
-
-	if resp.Success == false {
-		log.Fatalf("agent %s not found: %s", name, resp.ErrorMsg)
+	agentConfig, err := cl.GetAgent(ctx, name)
+	var nerr *client.NotFoundError
+	if errors.As(err, &nerr) {
+		return failf("%v", err)
+	} else if err != nil {
+		return failf("could not get agent %s: %v", name, err)
 	}
 
-	agentConfig := resp.Cfg
 	fmt.Printf("name: %s\n", agentConfig.Name)
 	fmt.Printf("url: %s\n", agentConfig.Url)
 	fmt.Printf("port: %d\n", agentConfig.Port)
@@ -177,4 +169,5 @@ func agentGet(cmd *cobra.Command, args []string) {
 		fmt.Printf("  %s: %s\n", kv.Key, kv.Value)
 	}
 	fmt.Printf("\n")
+	return nil
 }
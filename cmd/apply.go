@@ -3,17 +3,23 @@
 package cmd
 
 import (
-	"context"
+	"errors"
 	"fmt"
 	"log"
 
 	"github.com/spf13/cobra"
 
-	pbc "github.com/swinslow/peridot-core/pkg/controller"
 	"github.com/swinslow/peridotctl/internal/config"
-	"github.com/swinslow/peridotctl/internal/parser"
+	"github.com/swinslow/peridotctl/pkg/client"
+	"github.com/swinslow/peridotctl/pkg/parser"
 )
 
+var applyValuesFiles []string
+var applySetValues []string
+var applyOverlays []string
+var applyRender bool
+var applyLenient bool
+
 func init() {
 	var cmdApply = &cobra.Command{
 		Use:   "apply",
@@ -23,131 +29,127 @@ the peridot controller.
 
 Format: peridotctl apply YAMLFILE
 
-YAMLFILE: path to YAML file to apply`,
+YAMLFILE: path to YAML file to apply; JSON and TOML files are also
+accepted, detected by their extension or contents
+
+The YAML file's values may contain ${VAR} or ${VAR:-default}
+references, which are filled in from --set values, then --values files,
+then environment variables, then the default (if any). Write $${ for a
+literal ${. References in comments are left alone, and filled-in values
+are never read as YAML, so they can contain any characters. Filled-in
+values are strings, except that an agent's port may be filled in with
+a plain whole number, such as port: ${PORT}.
+
+Overlay files, listed under "overlays:" in the YAML file or passed with
+--overlay, patch agents and job set templates by name. Use --render to
+print the merged result without applying it.
+
+Other YAML files can be pulled in by listing them under "include:".
+Reusable sequences of steps can be defined under "stepGroups:" and used
+in a template with a step of type "group" naming the step group.`,
 		Args: cobra.ExactArgs(1),
-		Run:  apply,
+		RunE: apply,
 	}
+	cmdApply.Flags().StringArrayVar(&applyValuesFiles, "values", nil, "YAML file of variable values (may be repeated)")
+	cmdApply.Flags().StringArrayVar(&applySetValues, "set", nil, "variable value in format key=value (may be repeated)")
+	cmdApply.Flags().StringArrayVar(&applyOverlays, "overlay", nil, "overlay YAML file to merge in (may be repeated)")
+	cmdApply.Flags().BoolVar(&applyRender, "render", false, "print the merged YAML instead of applying it")
+	cmdApply.Flags().BoolVar(&applyLenient, "lenient", false, "ignore unknown fields in YAML files instead of failing")
 	rootCmd.AddCommand(cmdApply)
 }
 
-func apply(cmd *cobra.Command, args []string) {
+func apply(cmd *cobra.Command, args []string) error {
 	ctx, cancel := config.GetContext(timeout)
 	defer cancel()
-	defer conn.Close()
+	defer closeConn()
 
-	// load and parse YAML file, and confirm it is valid
-	req, err := parser.ParseYAML(args[0])
+	// collect variable values; --set values override --values files
+	vars, err := getApplyVars()
 	if err != nil {
-		log.Fatalf("error parsing %s: %v", args[0], err)
+		return exitErrorf(exitValidation, "error reading variable values: %v", err)
 	}
 
-	// build any Agents
-	err = applyAgents(ctx, req.Agents)
-	if err != nil {
-		log.Fatalf("error requesting agents: %v", err)
-	}
-
-	// build any JobSetTemplates
-	err = applyTemplates(ctx, req.Templates)
+	// load and parse YAML file, and confirm it is valid
+	req, err := parser.ParseYAML(args[0], parser.ParseOptions{
+		Vars:     vars,
+		Overlays: applyOverlays,
+		Warn: func(msg string) {
+			log.Printf("warning: %s", msg)
+		},
+		Lenient: applyLenient,
+	})
 	if err != nil {
-		log.Fatalf("error requesting JobSetTemplates: %v", err)
+		return exitErrorf(exitValidation, "error parsing %s: %v", args[0], err)
 	}
 
-	// we're done! will cancel and close connection
-}
-
-func applyAgents(ctx context.Context, agents []parser.PeridotAgent) error {
-	for _, agent := range agents {
-		// build configs into AgentKV list
-		kvs := []*pbc.AgentConfig_AgentKV{}
-		for k, v := range agent.Configs {
-			kv := pbc.AgentConfig_AgentKV{Key: k, Value: v}
-			kvs = append(kvs, &kv)
-		}
-
-		// build AgentConfig object
-		ac := &pbc.AgentConfig{
-			Name: agent.Name,
-			Url:  agent.URL,
-			Port: agent.Port,
-			Type: agent.TypeStr,
-			Kvs:  kvs,
-		}
-
-		resp, err := c.AddAgent(ctx, &pbc.AddAgentReq{Cfg: ac})
+	// if only rendering, print the merged request and stop here
+	if applyRender {
+		out, err := parser.RenderYAML(req)
 		if err != nil {
-			return fmt.Errorf("could not add agent %s: %v", agent.Name, err)
+			return failf("error rendering %s: %v", args[0], err)
 		}
+		fmt.Print(string(out))
+		return nil
+	}
 
-		if resp.Success {
-			fmt.Printf("agent %s successfully registered\n", agent.Name)
-		} else {
-			fmt.Printf("error registering agent %s: %s\n", agent.Name, resp.ErrorMsg)
-		}
+	// register agents and then JobSetTemplates
+	results, err := cl.ApplyManifest(ctx, req)
+	printApplyResults(results)
+	if err != nil {
+		return failf("error applying %s: %v", args[0], err)
+	}
+	if rejected := countRejected(results); rejected > 0 {
+		return exitErrorf(exitRejected, "%d of %d objects in %s were rejected by the controller", rejected, len(results), args[0])
 	}
 
+	// we're done! will cancel and close connection
 	return nil
 }
 
-func applyTemplates(ctx context.Context, templates []parser.PeridotJobSetTemplate) error {
-	for _, template := range templates {
+func getApplyVars() (map[string]string, error) {
+	vars := map[string]string{}
 
-		// translate template object into protobuf version of StepTemplates
-		steps, err := buildStepTemplates(template.Steps)
+	for _, valuesFile := range applyValuesFiles {
+		fileVars, err := parser.LoadValuesFile(valuesFile)
 		if err != nil {
-			return err
+			return nil, err
 		}
-
-		// build JobSetTemplate object
-		jst := &pbc.JobSetTemplate{
-			Name:  template.Name,
-			Steps: steps,
-		}
-
-		resp, err := c.AddJobSetTemplate(ctx, &pbc.AddJobSetTemplateReq{Jst: jst})
-		if err != nil {
-			return fmt.Errorf("could not add job set template %s: %v", template.Name, err)
+		for k, v := range fileVars {
+			vars[k] = v
 		}
+	}
 
-		if resp.Success {
-			fmt.Printf("job set template %s successfully registered\n", template.Name)
-		} else {
-			fmt.Printf("error registering job set template %s: %s\n", template.Name, resp.ErrorMsg)
-		}
+	setVars, err := parser.ParseSetValues(applySetValues)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range setVars {
+		vars[k] = v
 	}
 
-	return nil
+	return vars, nil
 }
 
-func buildStepTemplates(jstSteps []parser.PeridotJSTStep) ([]*pbc.StepTemplate, error) {
-	stepTemplates := []*pbc.StepTemplate{}
-
-	for _, jstStep := range jstSteps {
-		newStep := &pbc.StepTemplate{}
-		switch jstStep.TypeStr {
-		case "agent":
-			newStep.S = &pbc.StepTemplate_Agent{
-				Agent: &pbc.StepAgentTemplate{Name: jstStep.Name},
-			}
-			stepTemplates = append(stepTemplates, newStep)
-		case "jobset":
-			newStep.S = &pbc.StepTemplate_Jobset{
-				Jobset: &pbc.StepJobSetTemplate{Name: jstStep.Name},
-			}
-			stepTemplates = append(stepTemplates, newStep)
-		case "concurrent":
-			subSteps, err := buildStepTemplates(jstStep.Steps)
-			if err != nil {
-				return nil, err
-			}
-			newStep.S = &pbc.StepTemplate_Concurrent{
-				Concurrent: &pbc.StepConcurrentTemplate{Steps: subSteps},
-			}
-			stepTemplates = append(stepTemplates, newStep)
-		default:
-			return nil, fmt.Errorf("invalid step type %s in request", jstStep.TypeStr)
+// countRejected returns how many submitted objects the controller
+// refused.
+func countRejected(results []client.ApplyResult) int {
+	n := 0
+	for _, result := range results {
+		if result.Err != nil {
+			n++
 		}
 	}
+	return n
+}
 
-	return stepTemplates, nil
+// printApplyResults reports whether each submitted object was registered.
+func printApplyResults(results []client.ApplyResult) {
+	for _, result := range results {
+		var rerr *client.RejectedError
+		if errors.As(result.Err, &rerr) {
+			fmt.Printf("error registering %s %s: %s\n", result.Kind, result.Name, rerr.Msg)
+		} else {
+			fmt.Printf("%s %s successfully registered\n", result.Kind, result.Name)
+		}
+	}
 }
@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// completionCacheTTL is how long names looked up for completion are
+// reused before asking the controller again.
+const completionCacheTTL = 30 * time.Second
+
+func init() {
+	var cmdCompletion = &cobra.Command{
+		Use:   "completion",
+		Short: "Generate shell completion script",
+		Long: `Generate a completion script for bash, zsh, fish or
+powershell. Besides commands and flags, agent names, job set template
+names and job set IDs are completed by asking the peridot controller;
+results are cached briefly so that repeated tab presses are fast.
+
+Format: peridotctl completion SHELL
+
+	SHELL: bash, zsh, fish or powershell
+
+To load completions in the current bash session:
+
+	source <(peridotctl completion bash)
+
+For zsh, fish and powershell, save the output to a file in the
+shell's completion directory or profile.`,
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: []string{"bash", "zsh", "fish", "powershell"},
+		RunE:      completion,
+	}
+	rootCmd.AddCommand(cmdCompletion)
+}
+
+func completion(cmd *cobra.Command, args []string) error {
+	defer closeConn()
+
+	var err error
+	switch args[0] {
+	case "bash":
+		err = rootCmd.GenBashCompletionV2(os.Stdout, true)
+	case "zsh":
+		err = rootCmd.GenZshCompletion(os.Stdout)
+	case "fish":
+		err = rootCmd.GenFishCompletion(os.Stdout, true)
+	case "powershell":
+		err = rootCmd.GenPowerShellCompletionWithDesc(os.Stdout)
+	}
+	if err != nil {
+		return failf("could not generate %s completion: %v", args[0], err)
+	}
+	return nil
+}
+
+// completeFirstArg returns a ValidArgsFunction that completes a
+// command's first argument with names from lookup, cached under kind.
+func completeFirstArg(kind string, lookup func() ([]string, error)) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) != 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		// initConfig skips completion requests, so read the config and
+		// connect here now that the flags are parsed; the shell is
+		// connected already
+		if !inShell {
+			if err := initConfig(cmd, args); err != nil {
+				return nil, cobra.ShellCompDirectiveError
+			}
+			defer closeConn()
+		}
+
+		names, err := cachedLookup(kind, lookup)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+
+		matches := []string{}
+		for _, name := range names {
+			if strings.HasPrefix(name, toComplete) {
+				matches = append(matches, name)
+			}
+		}
+		return matches, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completionCache is the format of a cache file of looked-up names.
+type completionCache struct {
+	Address string
+	Time    time.Time
+	Names   []string
+}
+
+// cachedLookup returns the names from lookup, reusing the names from a
+// previous lookup of the same kind for the same controller address if
+// they are recent enough. Failing to read or write the cache is not an
+// error; the lookup is just done again. The shell keeps its connection
+// open, so it always does the lookup and sees names it just changed.
+func cachedLookup(kind string, lookup func() ([]string, error)) ([]string, error) {
+	if inShell {
+		return lookup()
+	}
+
+	cacheFile := ""
+	if dir, err := os.UserCacheDir(); err == nil {
+		cacheFile = filepath.Join(dir, "peridotctl", "completion-"+kind+".json")
+	}
+
+	if cacheFile != "" {
+		if data, err := ioutil.ReadFile(cacheFile); err == nil {
+			cache := completionCache{}
+			if json.Unmarshal(data, &cache) == nil && cache.Address == address && time.Since(cache.Time) < completionCacheTTL {
+				return cache.Names, nil
+			}
+		}
+	}
+
+	names, err := lookup()
+	if err != nil {
+		return nil, err
+	}
+
+	if cacheFile != "" {
+		data, err := json.Marshal(completionCache{Address: address, Time: time.Now(), Names: names})
+		if err == nil && os.MkdirAll(filepath.Dir(cacheFile), 0700) == nil {
+			ioutil.WriteFile(cacheFile, data, 0600)
+		}
+	}
+
+	return names, nil
+}
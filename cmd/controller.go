@@ -3,15 +3,29 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
-	pbc "github.com/swinslow/peridot-core/pkg/controller"
+	pbs "github.com/swinslow/peridot-core/pkg/status"
 	"github.com/swinslow/peridotctl/internal/config"
+	"github.com/swinslow/peridotctl/pkg/client"
 )
 
+var controllerWaitInterval time.Duration
+var controllerStopForce bool
+var controllerGracePeriod time.Duration
+var healthCheckAgents bool
+var healthAgentTimeout time.Duration
+var healthMaxJobSetAge time.Duration
+
 func init() {
 	var cmdController = &cobra.Command{
 		Use:   "controller",
@@ -19,7 +33,7 @@ func init() {
 		Long: `Manage the overall functionality of the
 	peridot controller, such as starting and stopping
 	it, and getting its current status.`,
-		Run: controllerStatus,
+		RunE: controllerStatus,
 	}
 	rootCmd.AddCommand(cmdController)
 
@@ -28,7 +42,7 @@ func init() {
 		Short: "Get peridot controller status",
 		Long: `Get the current status, health, output and
 	error messages for the peridot controller.`,
-		Run: controllerStatus,
+		RunE: controllerStatus,
 	}
 	cmdController.AddCommand(cmdControllerStatus)
 
@@ -37,40 +51,375 @@ func init() {
 		Short: "Start peridot controller",
 		Long: `Try to start the peridot controller to enable it
 	to begin receiving job sets.`,
-		Run: controllerStart,
+		RunE: controllerStart,
 	}
 	cmdController.AddCommand(cmdControllerStart)
+
+	var cmdControllerWait = &cobra.Command{
+		Use:   "wait",
+		Short: "Wait for peridot controller to be running",
+		Long: `Wait until the peridot controller can be reached and
+reports that it is running, checking every --interval. Use --timeout
+to give up after a while; otherwise it waits indefinitely.
+
+Format: peridotctl controller wait [--interval DURATION]`,
+		Args: cobra.NoArgs,
+		RunE: controllerWait,
+	}
+	cmdControllerWait.Flags().DurationVar(&controllerWaitInterval, "interval", time.Second, "how often to check the controller's status")
+	cmdController.AddCommand(cmdControllerWait)
+
+	var cmdControllerStop = &cobra.Command{
+		Use:   "stop",
+		Short: "Stop peridot controller",
+		Long: `Stop the peridot controller, and wait until it reports
+that it has stopped. By default this first waits for any running job
+sets to finish, checking every --interval. With --force, the controller
+is stopped straight away, and running job sets are left unfinished.
+
+With --grace-period, it only waits that long for running job sets to
+finish. If they haven't finished by then, the controller is not stopped
+and the command fails as timed out, unless --force is also given, in
+which case the controller is stopped anyway.
+
+Format: peridotctl controller stop [--force] [--grace-period DURATION] [--interval DURATION]`,
+		Args: cobra.NoArgs,
+		RunE: controllerStop,
+	}
+	cmdControllerStop.Flags().BoolVar(&controllerStopForce, "force", false, "stop without waiting for running job sets to finish, or once --grace-period is over")
+	cmdControllerStop.Flags().DurationVar(&controllerGracePeriod, "grace-period", 0, "how long to wait for running job sets to finish (0 for no limit)")
+	cmdControllerStop.Flags().DurationVar(&controllerWaitInterval, "interval", time.Second, "how often to check the controller's status")
+	cmdController.AddCommand(cmdControllerStop)
+
+	var cmdControllerRestart = &cobra.Command{
+		Use:   "restart",
+		Short: "Restart peridot controller",
+		Long: `Stop the peridot controller as "controller stop" does,
+wait until it reports that it has stopped, and then start it again and
+wait until it reports that it is running. Each change in the
+controller's status is reported along the way.
+
+Format: peridotctl controller restart [--force] [--grace-period DURATION] [--interval DURATION]`,
+		Args: cobra.NoArgs,
+		RunE: controllerRestart,
+	}
+	cmdControllerRestart.Flags().BoolVar(&controllerStopForce, "force", false, "stop without waiting for running job sets to finish, or once --grace-period is over")
+	cmdControllerRestart.Flags().DurationVar(&controllerGracePeriod, "grace-period", 0, "how long to wait for running job sets to finish (0 for no limit)")
+	cmdControllerRestart.Flags().DurationVar(&controllerWaitInterval, "interval", time.Second, "how often to check the controller's status")
+	cmdController.AddCommand(cmdControllerRestart)
+
+	var cmdControllerHealth = &cobra.Command{
+		Use:   "health",
+		Short: "Check peridot controller health",
+		Long: `Check that the peridot controller can be reached, is
+running and reports that it is healthy, for use by monitoring systems.
+With --check-agents, also check that every registered agent accepts
+connections. With --max-jobset-age, also check that no job set has been
+running for longer than that.
+
+The result is printed in the style of a Nagios plugin, or as JSON with
+--output json. Unlike other commands, it exits with the Nagios plugin
+codes: 0 if all checks pass, 1 for warnings such as degraded health or
+long-running job sets, 2 for critical problems such as the controller
+being unreachable or stopped, and 3 if a check could not be made or the
+command failed for any other reason, such as an invalid flag.
+
+Format: peridotctl controller health [--check-agents] [--agent-timeout DURATION] [--max-jobset-age DURATION]`,
+		Args: cobra.NoArgs,
+		RunE: controllerHealth,
+		// monitoring systems treat any other exit code as a result
+		Annotations: map[string]string{
+			failureCodeAnnotation: strconv.Itoa(int(client.HealthUnknown)),
+		},
+	}
+	cmdControllerHealth.Flags().BoolVar(&healthCheckAgents, "check-agents", false, "check that every registered agent accepts connections")
+	cmdControllerHealth.Flags().DurationVar(&healthAgentTimeout, "agent-timeout", 5*time.Second, "how long to wait when connecting to each agent")
+	cmdControllerHealth.Flags().DurationVar(&healthMaxJobSetAge, "max-jobset-age", 0, "warn about job sets running longer than this (0 to skip)")
+	cmdController.AddCommand(cmdControllerHealth)
 }
 
-func controllerStatus(cmd *cobra.Command, args []string) {
+func controllerStatus(cmd *cobra.Command, args []string) error {
 	ctx, cancel := config.GetContext(timeout)
 	defer cancel()
-	defer conn.Close()
+	defer closeConn()
 
-	resp, err := c.GetStatus(ctx, &pbc.GetStatusReq{})
+	resp, err := cl.GetStatus(ctx)
 	if err != nil {
-		log.Fatalf("could not get status: %v", err)
+		return failf("could not get status: %v", err)
 	}
 
 	fmt.Printf("status: %s\n", resp.RunStatus.String())
 	fmt.Printf("health: %s\n", resp.HealthStatus.String())
 	fmt.Printf("output: %s\n", resp.OutputMsg)
 	fmt.Printf("errors: %s\n", resp.ErrorMsg)
+	return nil
+}
+
+func controllerStart(cmd *cobra.Command, args []string) error {
+	ctx, cancel := config.GetContext(timeout)
+	defer cancel()
+	defer closeConn()
+
+	err := cl.Start(ctx)
+	var rerr *client.RejectedError
+	if errors.As(err, &rerr) {
+		return exitErrorf(exitRejected, "could not start controller: %s", rerr.Msg)
+	} else if err != nil {
+		return failf("could not start controller: %v", err)
+	}
+
+	fmt.Printf("controller is starting\n")
+	return nil
+}
+
+func controllerWait(cmd *cobra.Command, args []string) error {
+	ctx, cancel := config.GetContext(timeout)
+	defer cancel()
+	defer closeConn()
+
+	if controllerWaitInterval <= 0 {
+		return usageErrorf("invalid interval: %v", controllerWaitInterval)
+	}
+
+	resp, err := cl.WaitRunning(ctx, controllerWaitInterval)
+	if config.Interrupted(ctx) {
+		return exitErrorf(exitInterrupted, "stopped waiting for controller")
+	} else if err != nil {
+		return failf("controller is not running: %v", err)
+	}
+
+	fmt.Printf("controller is running\n")
+	fmt.Printf("health: %s\n", resp.HealthStatus.String())
+	return nil
+}
+
+func controllerStop(cmd *cobra.Command, args []string) error {
+	ctx, cancel := config.GetContext(timeout)
+	defer cancel()
+	defer closeConn()
+
+	if controllerWaitInterval <= 0 {
+		return usageErrorf("invalid interval: %v", controllerWaitInterval)
+	}
+
+	return stopController(ctx)
 }
 
-func controllerStart(cmd *cobra.Command, args []string) {
+func controllerRestart(cmd *cobra.Command, args []string) error {
 	ctx, cancel := config.GetContext(timeout)
 	defer cancel()
-	defer conn.Close()
+	defer closeConn()
+
+	if controllerWaitInterval <= 0 {
+		return usageErrorf("invalid interval: %v", controllerWaitInterval)
+	}
+
+	if err := stopController(ctx); err != nil {
+		return err
+	}
+
+	err := cl.Start(ctx)
+	var rerr *client.RejectedError
+	if errors.As(err, &rerr) {
+		return exitErrorf(exitRejected, "could not start controller: %s", rerr.Msg)
+	} else if err != nil {
+		return failf("could not start controller: %v", err)
+	}
+	fmt.Printf("controller is starting\n")
+
+	if err := waitControllerStatus(ctx, pbs.Status_STOPPED, pbs.Status_RUNNING); err != nil {
+		return err
+	}
+	fmt.Printf("controller is running\n")
+	return nil
+}
 
-	resp, err := c.Start(ctx, &pbc.StartReq{})
+// stopController stops the controller following --force and
+// --grace-period, and waits for it to report that it has stopped.
+func stopController(ctx context.Context) error {
+	if controllerGracePeriod < 0 {
+		return usageErrorf("invalid grace period: %v", controllerGracePeriod)
+	}
+
+	resp, err := cl.GetStatus(ctx)
+	if err != nil {
+		return failf("could not get status: %v", err)
+	}
+	if resp.RunStatus == pbs.Status_STOPPED {
+		fmt.Printf("controller is already stopped\n")
+		return nil
+	}
+
+	graceful := !controllerStopForce || controllerGracePeriod > 0
+	if graceful {
+		waitCtx := ctx
+		graceEnd := time.Now().Add(controllerGracePeriod)
+		if controllerGracePeriod > 0 {
+			var cancel context.CancelFunc
+			waitCtx, cancel = context.WithDeadline(ctx, graceEnd)
+			defer cancel()
+		}
+
+		err := cl.WaitJobSetsIdle(waitCtx, controllerWaitInterval, func(n int) {
+			fmt.Printf("waiting for job sets to finish: %d running\n", n)
+		})
+		switch {
+		case config.Interrupted(ctx):
+			return exitErrorf(exitInterrupted, "stopped waiting for job sets to finish; the controller was not stopped")
+		case err != nil && ctx.Err() == nil && controllerGracePeriod > 0 && !time.Now().Before(graceEnd):
+			// the call in progress may have failed with its own
+			// deadline error just before waitCtx noticed
+			if !controllerStopForce {
+				return exitErrorf(exitTimeout, "job sets still running after grace period of %v; the controller was not stopped", controllerGracePeriod)
+			}
+			fmt.Printf("grace period of %v is over; stopping anyway\n", controllerGracePeriod)
+			graceful = false
+		case err != nil:
+			return failf("could not check for running job sets: %v", err)
+		}
+	}
+
+	if err := cl.Stop(ctx); err != nil {
+		return failf("could not stop controller: %v", err)
+	}
+	fmt.Printf("controller is stopping\n")
+
+	// a job set can be started after the last check that none were
+	// running and before the controller stopped; it can't be saved by
+	// then, but say that it didn't get to finish
+	if graceful {
+		if err := warnRunningJobSets(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := waitControllerStatus(ctx, resp.RunStatus, pbs.Status_STOPPED); err != nil {
+		return err
+	}
+	fmt.Printf("controller is stopped\n")
+	return nil
+}
+
+// warnRunningJobSets logs a warning listing any job sets that are
+// still running.
+func warnRunningJobSets(ctx context.Context) error {
+	jobSets, err := cl.GetAllJobSets(ctx)
 	if err != nil {
-		log.Fatalf("could not start controller: %v", err)
+		return failf("could not check for running job sets: %v", err)
+	}
+
+	ids := []string{}
+	for _, jsd := range jobSets {
+		if jsd.St.RunStatus != pbs.Status_STOPPED {
+			ids = append(ids, strconv.FormatUint(jsd.JobSetID, 10))
+		}
+	}
+	if len(ids) > 0 {
+		log.Printf("warning: job sets started while the controller was stopping did not finish: %s", strings.Join(ids, ", "))
+	}
+	return nil
+}
+
+// waitControllerStatus waits for the controller to report the wanted
+// run status, printing each change from the status it had before.
+func waitControllerStatus(ctx context.Context, from pbs.Status, want pbs.Status) error {
+	last := from
+	_, err := cl.WaitStatus(ctx, want, controllerWaitInterval, func(st pbs.Status) {
+		if st != last {
+			fmt.Printf("controller status: %s -> %s\n", last.String(), st.String())
+			last = st
+		}
+	})
+	if config.Interrupted(ctx) {
+		return exitErrorf(exitInterrupted, "stopped waiting for controller to be %s", want.String())
+	} else if err != nil {
+		return failf("controller did not reach status %s: %v", want.String(), err)
+	}
+	return nil
+}
+
+func controllerHealth(cmd *cobra.Command, args []string) error {
+	ctx, cancel := config.GetContext(timeout)
+	defer cancel()
+	defer closeConn()
+
+	if healthMaxJobSetAge < 0 {
+		return usageErrorf("invalid maximum job set age: %v", healthMaxJobSetAge)
 	}
 
-	if resp.Starting {
-		fmt.Printf("controller is starting\n")
+	report := cl.CheckHealth(ctx, client.HealthOptions{
+		CheckAgents:  healthCheckAgents,
+		AgentTimeout: healthAgentTimeout,
+		MaxJobSetAge: healthMaxJobSetAge,
+		Now:          nowFunc,
+	})
+
+	if outputFormat == outputJSON {
+		if err := printHealthJSON(report); err != nil {
+			return err
+		}
 	} else {
-		log.Fatalf("could not start controller: %v", resp.ErrorMsg)
+		printHealthNagios(report)
+	}
+
+	// the report says what is wrong, so the exit code is all that's left
+	if report.State != client.HealthOK {
+		return &cmdError{code: int(report.State)}
+	}
+	return nil
+}
+
+// printHealthNagios prints a health report as a Nagios plugin would:
+// a summary line, and then a line for each check.
+func printHealthNagios(report *client.HealthReport) {
+	// the summary is the first check, or else the problems found
+	summary := []string{}
+	for _, check := range report.Checks {
+		if check.State != client.HealthOK {
+			summary = append(summary, check.Msg)
+		}
+	}
+	if len(summary) == 0 {
+		summary = append(summary, report.Checks[0].Msg)
+	}
+
+	fmt.Printf("PERIDOT %s - %s\n", report.State.String(), strings.Join(summary, "; "))
+	for _, check := range report.Checks {
+		fmt.Printf("%s: %s - %s\n", check.Name, check.State.String(), check.Msg)
+	}
+}
+
+// healthJSON is the JSON form of a health report.
+type healthJSON struct {
+	State  string            `json:"state"`
+	Code   int               `json:"code"`
+	Checks []healthCheckJSON `json:"checks"`
+}
+
+type healthCheckJSON struct {
+	Name    string `json:"name"`
+	State   string `json:"state"`
+	Message string `json:"message"`
+}
+
+func printHealthJSON(report *client.HealthReport) error {
+	hj := healthJSON{
+		State:  report.State.String(),
+		Code:   int(report.State),
+		Checks: []healthCheckJSON{},
+	}
+	for _, check := range report.Checks {
+		hj.Checks = append(hj.Checks, healthCheckJSON{
+			Name:    check.Name,
+			State:   check.State.String(),
+			Message: check.Msg,
+		})
+	}
+
+	out, err := json.MarshalIndent(hj, "", "  ")
+	if err != nil {
+		return failf("could not format health report: %v", err)
 	}
+	fmt.Println(string(out))
+	return nil
 }
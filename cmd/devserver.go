@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package cmd
+
+import (
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/swinslow/peridotctl/pkg/fakecontroller"
+)
+
+var devServerStepInterval time.Duration
+var devServerManualStart bool
+
+func init() {
+	var cmdDevServer = &cobra.Command{
+		Use:   "dev-server",
+		Short: "Run fake peridot controller",
+		Long: `Run an in-memory fake peridot controller, for demos and
+trying out peridotctl without a real controller. It listens on the
+address given by --address, so other peridotctl commands with the same
+settings will talk to it.
+
+Job sets move forward one step every --step-interval, with agent steps
+always succeeding unless the agent has a config value "fake-result" of
+"degraded" or "error". Nothing is saved when the fake controller exits.
+
+Format: peridotctl dev-server [--step-interval DURATION] [--manual-start]`,
+		Args: cobra.NoArgs,
+		RunE: devServer,
+	}
+	cmdDevServer.Flags().DurationVar(&devServerStepInterval, "step-interval", 2*time.Second, "how often job sets move forward")
+	cmdDevServer.Flags().BoolVar(&devServerManualStart, "manual-start", false, "wait for \"controller start\" instead of starting immediately")
+	rootCmd.AddCommand(cmdDevServer)
+}
+
+func devServer(cmd *cobra.Command, args []string) error {
+	defer closeConn()
+
+	if devServerStepInterval <= 0 {
+		return usageErrorf("invalid step interval: %v", devServerStepInterval)
+	}
+
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		return failf("could not listen on %s: %v", address, err)
+	}
+
+	srv := fakecontroller.New(fakecontroller.Options{
+		StepInterval: devServerStepInterval,
+		Started:      !devServerManualStart,
+		Logf:         log.Printf,
+	})
+
+	// stop cleanly on Ctrl-C or when killed
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		<-sigs
+		srv.Shutdown()
+	}()
+
+	log.Printf("fake peridot controller listening on %s", lis.Addr())
+	if err := srv.Serve(lis); err != nil {
+		return failf("error serving: %v", err)
+	}
+	return nil
+}
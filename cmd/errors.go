@@ -0,0 +1,208 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/swinslow/peridotctl/pkg/client"
+)
+
+// Exit codes, so that scripts can tell failures apart. These are listed
+// in the root command's help, and must stay the same between versions.
+const (
+	exitOK          = 0
+	exitError       = 1
+	exitUsage       = 2
+	exitNotFound    = 3
+	exitRejected    = 4
+	exitUnavailable = 5
+	exitTimeout     = 6
+	exitValidation  = 7
+	exitInterrupted = 130
+)
+
+// exitKinds are the names for each exit code, used in JSON error
+// objects.
+var exitKinds = map[int]string{
+	exitError:       "error",
+	exitUsage:       "usage",
+	exitNotFound:    "not-found",
+	exitRejected:    "rejected",
+	exitUnavailable: "unavailable",
+	exitTimeout:     "timeout",
+	exitValidation:  "validation",
+	exitInterrupted: "interrupted",
+}
+
+// exitCodesHelp describes the exit codes for the root command's help.
+const exitCodesHelp = `Exit codes:
+  0    success
+  1    other error
+  2    usage error, such as a missing argument or invalid flag value
+  3    object not found
+  4    request rejected by the controller
+  5    controller unavailable
+  6    timed out
+  7    invalid manifest or other input file
+  130  interrupted`
+
+// errorObject is the JSON form of an error, written to stderr with
+// --output json.
+type errorObject struct {
+	Error errorDetails `json:"error"`
+}
+
+type errorDetails struct {
+	Code    int    `json:"code"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// cmdError is an error that makes peridotctl exit with a particular
+// code. Commands return these from RunE, and Execute reports them.
+type cmdError struct {
+	code int
+	// msg is the message to report, or empty if the command has
+	// already said why it failed.
+	msg string
+}
+
+func (e *cmdError) Error() string {
+	return e.msg
+}
+
+// exitCodeFor returns the exit code for a failure caused by err.
+func exitCodeFor(err error) int {
+	var cerr *cmdError
+	if errors.As(err, &cerr) {
+		return cerr.code
+	}
+	var ierr *client.InterruptedError
+	if errors.As(err, &ierr) {
+		return exitCodeFor(ierr.Err)
+	}
+	var nerr *client.NotFoundError
+	if errors.As(err, &nerr) {
+		return exitNotFound
+	}
+	var rerr *client.RejectedError
+	if errors.As(err, &rerr) {
+		return exitRejected
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	case errors.Is(err, context.DeadlineExceeded):
+		return exitTimeout
+	}
+
+	var serr interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &serr) {
+		switch serr.GRPCStatus().Code() {
+		case codes.NotFound:
+			return exitNotFound
+		case codes.Unavailable:
+			return exitUnavailable
+		case codes.DeadlineExceeded:
+			return exitTimeout
+		case codes.Canceled:
+			return exitInterrupted
+		}
+	}
+	return exitError
+}
+
+// failureCodeAnnotation is the key of a command annotation giving the
+// exit code for any failure of that command, for commands whose exit
+// codes follow some other convention than exitCodesHelp.
+const failureCodeAnnotation = "failureCode"
+
+// reportError writes an error message to stderr, as plain text or as
+// a JSON error object depending on --output. The JSON object gives
+// the exit code, and names the kind of failure by the exit code most
+// commands would use for it.
+func reportError(code int, kind int, msg string) {
+	if outputFormat != outputJSON {
+		log.Print(msg)
+		return
+	}
+
+	out, err := json.Marshal(errorObject{Error: errorDetails{
+		Code:    code,
+		Kind:    exitKinds[kind],
+		Message: msg,
+	}})
+	if err != nil {
+		// can't happen, but don't lose the message
+		log.Print(msg)
+		return
+	}
+	fmt.Fprintln(os.Stderr, string(out))
+}
+
+// reportFailure reports the error returned by cmd and returns the code
+// to exit with. Errors that aren't cmdErrors come from cobra itself,
+// such as unknown flags or missing arguments, so they are usage errors.
+// A cmdError with no message is a result the command has already
+// reported, and its code is used as it is.
+func reportFailure(cmd *cobra.Command, err error) int {
+	code := exitUsage
+	var cerr *cmdError
+	if errors.As(err, &cerr) {
+		code = cerr.code
+	}
+	msg := err.Error()
+	if msg == "" {
+		return code
+	}
+
+	kind := code
+	if cmd != nil {
+		if fc, err := strconv.Atoi(cmd.Annotations[failureCodeAnnotation]); err == nil {
+			code = fc
+		}
+	}
+	reportError(code, kind, msg)
+	return code
+}
+
+// exitErrorf returns an error that makes peridotctl exit with the
+// given code.
+func exitErrorf(code int, format string, v ...interface{}) error {
+	return &cmdError{code: code, msg: fmt.Sprintf(format, v...)}
+}
+
+// failf returns an error like exitErrorf, choosing the exit code from
+// the first error among its arguments, if any.
+func failf(format string, v ...interface{}) error {
+	return exitErrorf(exitCodeForArgs(v), format, v...)
+}
+
+// exitCodeForArgs returns the exit code for the first error in v, or
+// exitError if there isn't one.
+func exitCodeForArgs(v []interface{}) int {
+	for _, arg := range v {
+		if err, ok := arg.(error); ok {
+			return exitCodeFor(err)
+		}
+	}
+	return exitError
+}
+
+// usageErrorf returns an error for a usage error, such as an invalid
+// flag value.
+func usageErrorf(format string, v ...interface{}) error {
+	return exitErrorf(exitUsage, format, v...)
+}
@@ -3,8 +3,9 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"strconv"
 	"strings"
 	"time"
@@ -13,9 +14,20 @@ import (
 
 	pbc "github.com/swinslow/peridot-core/pkg/controller"
 	"github.com/swinslow/peridotctl/internal/config"
-	"github.com/swinslow/peridotctl/internal/outputfmt"
+	"github.com/swinslow/peridotctl/pkg/client"
+	"github.com/swinslow/peridotctl/pkg/outputfmt"
 )
 
+var graphFormat string
+var jobSetGetTree bool
+var jobSetGetExpandAll bool
+var jobSetTimeFormat string
+var jobSetUTC bool
+var jobSetStartWait bool
+
+// jobSetWaitInterval is how often to poll a job set while waiting for it.
+const jobSetWaitInterval = 2 * time.Second
+
 func init() {
 	var cmdJobSet = &cobra.Command{
 		Use:   "jobset",
@@ -25,6 +37,8 @@ func init() {
 		and request to start a new job set.`,
 		//Run: jobSetList,
 	}
+	cmdJobSet.PersistentFlags().StringVar(&jobSetTimeFormat, "time-format", outputfmt.TimeRFC3339, "how to show times (rfc3339, relative or unix)")
+	cmdJobSet.PersistentFlags().BoolVar(&jobSetUTC, "utc", false, "show times in UTC instead of local time")
 	rootCmd.AddCommand(cmdJobSet)
 
 	var cmdJobSetList = &cobra.Command{
@@ -32,7 +46,7 @@ func init() {
 		Short: "List job sets",
 		Long: `Get information about job sets requested for the
 		peridot controller.`,
-		Run: jobSetList,
+		RunE: jobSetList,
 	}
 	cmdJobSet.AddCommand(cmdJobSetList)
 
@@ -44,10 +58,15 @@ func init() {
 Format: peridotctl jobset start NAME [CFGSTRING]
 
 	NAME:      Name of job set template
-	CFGSTRING: Optional: job set configuration values (in format key1:value1;key2:value2;...)`,
-		Args: cobra.RangeArgs(1, 2),
-		Run:  jobSetStart,
+	CFGSTRING: Optional: job set configuration values (in format key1:value1;key2:value2;...)
+
+With --wait, wait until the job set has stopped and then show its
+status.`,
+		Args:              cobra.RangeArgs(1, 2),
+		ValidArgsFunction: completeFirstArg("templates", lookupTemplateNames),
+		RunE:              jobSetStart,
 	}
+	cmdJobSetStart.Flags().BoolVar(&jobSetStartWait, "wait", false, "wait for the job set to stop")
 	cmdJobSet.AddCommand(cmdJobSetStart)
 
 	var cmdJobSetGet = &cobra.Command{
@@ -55,38 +74,67 @@ Format: peridotctl jobset start NAME [CFGSTRING]
 		Short: "Get info on job set",
 		Long: `Get information about a previously-started job set
 		for the peridot controller.`,
-		Args: cobra.ExactArgs(1),
-		Run:  jobSetGet,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeFirstArg("jobsets", lookupJobSetIDs),
+		RunE:              jobSetGet,
 	}
+	cmdJobSetGet.Flags().BoolVar(&jobSetGetTree, "tree", false, "draw steps as a tree with status glyphs and elapsed times")
+	cmdJobSetGet.Flags().BoolVar(&jobSetGetExpandAll, "expand-all", false, "with --tree, also show steps of finished concurrent steps")
 	cmdJobSet.AddCommand(cmdJobSetGet)
+
+	var cmdJobSetGraph = &cobra.Command{
+		Use:   "graph",
+		Short: "Draw job set as a graph",
+		Long: `Draw the steps of a previously-started job set as a
+Graphviz DOT or Mermaid flowchart, with each step colored by its status
+and any sub-job sets expanded into their own steps.
+
+Format: peridotctl jobset graph [--format dot|mermaid] ID
+
+	ID: ID of job set`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeFirstArg("jobsets", lookupJobSetIDs),
+		RunE:              jobSetGraph,
+	}
+	cmdJobSetGraph.Flags().StringVar(&graphFormat, "format", outputfmt.GraphDOT, "graph format (dot or mermaid)")
+	cmdJobSet.AddCommand(cmdJobSetGraph)
 }
 
-func jobSetList(cmd *cobra.Command, args []string) {
+func jobSetList(cmd *cobra.Command, args []string) error {
 	ctx, cancel := config.GetContext(timeout)
 	defer cancel()
-	defer conn.Close()
+	defer closeConn()
+
+	topts, err := getJobSetTimeOptions()
+	if err != nil {
+		return err
+	}
 
-	resp, err := c.GetAllJobSets(ctx, &pbc.GetAllJobSetsReq{})
+	jobSets, err := cl.GetAllJobSets(ctx)
 	if err != nil {
-		log.Fatalf("could not get job sets: %v", err)
+		return failf("could not get job sets: %v", err)
 	}
 
 	fmt.Printf("Job sets:\n\n")
 
-	for _, jsd := range resp.JobSets {
+	for _, jsd := range jobSets {
 		fmt.Printf("ID: %d\n", jsd.JobSetID)
 		fmt.Printf("template name: %s\n", jsd.TemplateName)
 		fmt.Printf("runStatus: %s\n", jsd.St.RunStatus.String())
 		fmt.Printf("health: %s\n", jsd.St.HealthStatus.String())
+		fmt.Printf("timeStarted: %s\n", outputfmt.FormatTime(jsd.St.TimeStarted, topts))
+		fmt.Printf("timeFinished: %s\n", outputfmt.FormatTime(jsd.St.TimeFinished, topts))
+		fmt.Printf("duration: %s\n", outputfmt.FormatDuration(jsd.St.TimeStarted, jsd.St.TimeFinished, topts.Now))
 		fmt.Printf("\n")
 	}
 	fmt.Printf("\n")
+	return nil
 }
 
-func jobSetStart(cmd *cobra.Command, args []string) {
+func jobSetStart(cmd *cobra.Command, args []string) error {
 	ctx, cancel := config.GetContext(timeout)
 	defer cancel()
-	defer conn.Close()
+	defer closeConn()
 
 	name := args[0]
 	var cfgStr string
@@ -96,70 +144,128 @@ func jobSetStart(cmd *cobra.Command, args []string) {
 
 	// check whether values are okay
 	if name == "" {
-		log.Fatal("no job set template name specified")
+		return usageErrorf("no job set template name specified")
 	}
 
 	// extract configuration key-value pairs -- semicolons separating pairs,
 	// colons separating key from value within a pair
 	cfgs := config.ExtractKVs(cfgStr)
 
-	// build into JobSetConfig list
-	kvs := []*pbc.JobSetConfig{}
-	for k, v := range cfgs {
-		kv := pbc.JobSetConfig{Key: k, Value: v}
-		kvs = append(kvs, &kv)
+	jobSetID, err := cl.StartJobSet(ctx, name, cfgs)
+	var rerr *client.RejectedError
+	if errors.As(err, &rerr) {
+		return exitErrorf(exitRejected, "error starting job set for template %s: %s", name, rerr.Msg)
+	} else if err != nil {
+		return failf("could not start job set for template %s: %v", name, err)
 	}
 
-	resp, err := c.StartJobSet(ctx, &pbc.StartJobSetReq{
-		JstName: name,
-		Cfgs:    kvs,
-	})
-	if err != nil {
-		log.Fatalf("could not start job set for template %s: %v", name, err)
-	}
+	fmt.Printf("job set started for template %s with ID %d\n", name, jobSetID)
 
-	if resp.Success {
-		fmt.Printf("job set started for template %s with ID %d\n", name, resp.JobSetID)
-	} else {
-		fmt.Printf("error starting job set for template %s: %s\n", name, resp.ErrorMsg)
+	if jobSetStartWait {
+		jsd, err := cl.WaitJobSet(ctx, jobSetID, jobSetWaitInterval)
+		if config.Interrupted(ctx) {
+			return exitErrorf(exitInterrupted, "stopped waiting for job set with ID %d, which is still running", jobSetID)
+		} else if err != nil {
+			return failf("could not wait for job set with ID %d: %v", jobSetID, err)
+		}
+		fmt.Printf("job set with ID %d stopped with health %s\n", jobSetID, jsd.St.HealthStatus.String())
 	}
 	fmt.Printf("\n")
+	return nil
 }
 
-func jobSetGet(cmd *cobra.Command, args []string) {
+func jobSetGet(cmd *cobra.Command, args []string) error {
 	ctx, cancel := config.GetContext(timeout)
 	defer cancel()
-	defer conn.Close()
+	defer closeConn()
 
 	jobSetIDStr := args[0]
+	topts, err := getJobSetTimeOptions()
+	if err != nil {
+		return err
+	}
 
 	jobSetIDInt, err := strconv.Atoi(jobSetIDStr)
 	if err != nil || jobSetIDInt < 0 {
-		log.Fatalf("invalid job set ID: %s", jobSetIDStr)
+		return usageErrorf("invalid job set ID: %s", jobSetIDStr)
 	}
 
-	resp, err := c.GetJobSet(ctx, &pbc.GetJobSetReq{JobSetID: uint64(jobSetIDInt)})
-	if err != nil {
-		log.Fatalf("could not get job set with ID %d: %v", jobSetIDInt, err)
+	jsd, err := cl.GetJobSet(ctx, uint64(jobSetIDInt))
+	var nerr *client.NotFoundError
+	if errors.As(err, &nerr) {
+		return exitErrorf(exitNotFound, "job set with ID %d not found: %s", jobSetIDInt, nerr.Msg)
+	} else if err != nil {
+		return failf("could not get job set with ID %d: %v", jobSetIDInt, err)
 	}
 
-	if resp.Success == false {
-		log.Fatalf("job set with ID %d not found: %s", jobSetIDInt, resp.ErrorMsg)
+	if jobSetGetTree {
+		fmt.Println(strings.Join(outputfmt.ConvertJobSetTree(jsd, jobSetGetter(ctx), outputfmt.TreeOptions{
+			ExpandAll: jobSetGetExpandAll,
+			Now:       topts.Now,
+		}), "\n"))
+		return nil
 	}
 
-	jsd := resp.JobSet
 	fmt.Printf("job set details:\n\n")
 	fmt.Printf("  - id: %d\n", jsd.JobSetID)
 	fmt.Printf("    templateName: %s\n", jsd.TemplateName)
 	fmt.Printf("    status:\n")
 	fmt.Printf("      - runStatus: %s\n", jsd.St.RunStatus.String())
 	fmt.Printf("        health: %s\n", jsd.St.HealthStatus.String())
-	fmt.Printf("        timeStarted: %s\n", time.Unix(jsd.St.TimeStarted, 0).String())
-	fmt.Printf("        timeFinished: %s\n", time.Unix(jsd.St.TimeFinished, 0).String())
+	fmt.Printf("        timeStarted: %s\n", outputfmt.FormatTime(jsd.St.TimeStarted, topts))
+	fmt.Printf("        timeFinished: %s\n", outputfmt.FormatTime(jsd.St.TimeFinished, topts))
+	fmt.Printf("        duration: %s\n", outputfmt.FormatDuration(jsd.St.TimeStarted, jsd.St.TimeFinished, topts.Now))
 	fmt.Printf("        outputMessages: %s\n", jsd.St.OutputMessages)
 	fmt.Printf("        errorMessages: %s\n", jsd.St.ErrorMessages)
 	fmt.Printf("    steps:\n")
 	fmt.Println(strings.Join(outputfmt.ConvertSteps(jsd.Steps, 4), "\n"))
 
 	fmt.Printf("\n")
+	return nil
+}
+
+func jobSetGraph(cmd *cobra.Command, args []string) error {
+	ctx, cancel := config.GetContext(timeout)
+	defer cancel()
+	defer closeConn()
+
+	jobSetIDStr := args[0]
+
+	jobSetIDInt, err := strconv.Atoi(jobSetIDStr)
+	if err != nil || jobSetIDInt < 0 {
+		return usageErrorf("invalid job set ID: %s", jobSetIDStr)
+	}
+
+	getJobSet := jobSetGetter(ctx)
+	jsd, err := getJobSet(uint64(jobSetIDInt))
+	if err != nil {
+		return failf("could not get job set with ID %d: %v", jobSetIDInt, err)
+	}
+
+	out, err := outputfmt.JobSetGraph(jsd, getJobSet).Render(graphFormat)
+	if err != nil {
+		return usageErrorf("could not graph job set with ID %d: %v", jobSetIDInt, err)
+	}
+	fmt.Print(out)
+	return nil
+}
+
+// jobSetGetter returns a function that looks up job sets using ctx,
+// for expanding sub-job sets when formatting a job set.
+func jobSetGetter(ctx context.Context) func(id uint64) (*pbc.JobSetDetails, error) {
+	return func(id uint64) (*pbc.JobSetDetails, error) {
+		return cl.GetJobSet(ctx, id)
+	}
+}
+
+func getJobSetTimeOptions() (outputfmt.TimeOptions, error) {
+	if err := outputfmt.ValidateTimeFormat(jobSetTimeFormat); err != nil {
+		return outputfmt.TimeOptions{}, usageErrorf("%v", err)
+	}
+
+	return outputfmt.TimeOptions{
+		Format: jobSetTimeFormat,
+		UTC:    jobSetUTC,
+		Now:    nowFunc(),
+	}, nil
 }
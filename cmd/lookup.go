@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/swinslow/peridotctl/internal/config"
+)
+
+// lookupTimeout is the timeout for looking up names to complete, which
+// should be quick even if --timeout is not set.
+const lookupTimeout = 2 * time.Second
+
+// lookupAgentNames gets the names of all registered agents.
+func lookupAgentNames() ([]string, error) {
+	ctx, cancel := config.GetContext(lookupTimeout)
+	defer cancel()
+
+	agentConfigs, err := cl.GetAllAgents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, agentConfig := range agentConfigs {
+		names = append(names, agentConfig.Name)
+	}
+	return names, nil
+}
+
+// lookupTemplateNames gets the names of all registered job set templates.
+func lookupTemplateNames() ([]string, error) {
+	ctx, cancel := config.GetContext(lookupTimeout)
+	defer cancel()
+
+	jsts, err := cl.GetAllJobSetTemplates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, jst := range jsts {
+		names = append(names, jst.Name)
+	}
+	return names, nil
+}
+
+// lookupJobSetIDs gets the IDs of all job sets.
+func lookupJobSetIDs() ([]string, error) {
+	ctx, cancel := config.GetContext(lookupTimeout)
+	defer cancel()
+
+	jobSets, err := cl.GetAllJobSets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, jsd := range jobSets {
+		ids = append(ids, fmt.Sprintf("%d", jsd.JobSetID))
+	}
+	return ids, nil
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"google.golang.org/grpc"
 
@@ -13,36 +15,69 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
-	pbc "github.com/swinslow/peridot-core/pkg/controller"
+	"github.com/swinslow/peridotctl/internal/config"
+	"github.com/swinslow/peridotctl/pkg/client"
 )
 
 var cfgFile string
 var address string
-var timeout int
+var timeout time.Duration
+var dialTimeout time.Duration
+var rpcTimeout time.Duration
+var recordFile string
+var replayFile string
+var verbosity int
+var debug bool
+var retries int
+var retryBackoff time.Duration
+var waitForReady bool
+var outputFormat string
 
-// connection details
-var conn *grpc.ClientConn
-var c pbc.ControllerClient
+// values for --output
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// connection to the controller, set up once flags and config are read
+var cl *client.Client
+
+// recorder is set if calls are being recorded with --record
+var recorder *client.Recorder
+
+// inShell is true while running commands from peridotctl shell, which
+// keeps the connection open between commands and returns to its prompt
+// on errors instead of exiting.
+var inShell bool
 
 var rootCmd = &cobra.Command{
 	Use:   "peridotctl",
 	Short: "CLI tool for interacting with peridot",
 	Long: `peridotctl is a CLI tool that enables interacting
 with a peridot controller. It can be used to configure templates,
-start new job sets, and get info about running jobs.`,
+start new job sets, and get info about running jobs.
+
+Errors are written to stderr, as JSON objects with --output json, and
+peridotctl exits with a code saying what kind of failure it was.
+
+` + exitCodesHelp,
+	// errors are reported by Execute, to stderr and with their own
+	// exit code
+	SilenceErrors: true,
+	// flags and config are read once the command line has been parsed,
+	// and before running any command
+	PersistentPreRunE: initConfig,
 	//	Run: func(cmd *cobra.Command, args []string) { },
 }
 
 // Execute is the root command's execution entry point.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	if cmd, err := rootCmd.ExecuteC(); err != nil {
+		os.Exit(reportFailure(cmd, err))
 	}
 }
 
 func init() {
-	cobra.OnInitialize(initConfig)
 
 	// address on disk for configuration file
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.peridotctl.yaml)")
@@ -51,26 +86,64 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&address, "address", "localhost:8900", "address of peridot controller gRPC server")
 	viper.BindPFlag("address", rootCmd.PersistentFlags().Lookup("address"))
 
-	// timeout in seconds to wait for calls; 0 (default) means no timeout
-	rootCmd.PersistentFlags().IntVar(&timeout, "timeout", 0, "timeout in seconds to wait for response to calls")
+	// timeouts, as durations such as 30s or as plain seconds; 0 (the
+	// default) means no timeout. --timeout covers the whole command,
+	// --rpc-timeout each call within it, and --dial-timeout waiting
+	// for the connection to the controller before each call.
+	rootCmd.PersistentFlags().String("timeout", "0", "time limit for each command, such as 30s or 2m (a plain number is seconds)")
 	viper.BindPFlag("timeout", rootCmd.PersistentFlags().Lookup("timeout"))
+	rootCmd.PersistentFlags().String("rpc-timeout", "0", "time limit for each call to the controller")
+	viper.BindPFlag("rpc-timeout", rootCmd.PersistentFlags().Lookup("rpc-timeout"))
+	rootCmd.PersistentFlags().String("dial-timeout", "0", "time to wait for the connection to the controller before each call")
+	viper.BindPFlag("dial-timeout", rootCmd.PersistentFlags().Lookup("dial-timeout"))
 
-	fmt.Printf("==> address is %s\n", address)
-	dialServer()
-	if conn == nil {
-		log.Fatalf("could not connect to peridot controller")
-	}
+	// format for reporting errors, and for output of commands that
+	// support it, for scripts
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", outputText, "format for errors on stderr and for controller health (text or json)")
+	viper.BindPFlag("output", rootCmd.PersistentFlags().Lookup("output"))
+
+	// recording calls to the controller, or replaying a recording
+	// instead of connecting to one
+	rootCmd.PersistentFlags().StringVar(&recordFile, "record", "", "record calls to the controller in this file")
+	rootCmd.PersistentFlags().StringVar(&replayFile, "replay", "", "answer calls from a file written by --record instead of connecting")
+
+	// logging of calls to the controller on stderr: -v for methods,
+	// status codes and latency, -vv to add metadata, and -vvv or
+	// --debug to add request and response messages
+	rootCmd.PersistentFlags().CountVarP(&verbosity, "verbose", "v", "log calls to the controller on stderr (repeat for more detail)")
+	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "log calls to the controller in full detail, same as -vvv")
+
+	// retrying calls that only read from the controller, if it is
+	// unavailable, with the wait doubling after each try
+	rootCmd.PersistentFlags().IntVar(&retries, "retries", client.DefaultRetryPolicy.Retries, "times to retry read calls if the controller is unavailable")
+	viper.BindPFlag("retries", rootCmd.PersistentFlags().Lookup("retries"))
+	rootCmd.PersistentFlags().DurationVar(&retryBackoff, "retry-backoff", client.DefaultRetryPolicy.InitialBackoff, "time to wait before the first retry")
+	viper.BindPFlag("retry-backoff", rootCmd.PersistentFlags().Lookup("retry-backoff"))
+
+	// waiting for the controller to be reachable instead of failing
+	rootCmd.PersistentFlags().BoolVar(&waitForReady, "wait-for-ready", false, "wait for the controller to be reachable instead of failing calls straight away")
+	viper.BindPFlag("wait-for-ready", rootCmd.PersistentFlags().Lookup("wait-for-ready"))
 }
 
-func initConfig() {
+func initConfig(cmd *cobra.Command, args []string) error {
+	// cobra runs this for completion requests before parsing the flags
+	// of the command being completed, so completeFirstArg calls it
+	// again once they are parsed
+	if cmd.Name() == cobra.ShellCompRequestCmd || cmd.Name() == cobra.ShellCompNoDescRequestCmd {
+		return nil
+	}
+
+	// the command line has been parsed, so errors from here on are not
+	// about how the command was used
+	cmd.SilenceUsage = true
+
 	// check whether config file path is set in flag.
 	if cfgFile != "" {
 		viper.SetConfigFile(cfgFile)
 	} else {
 		home, err := homedir.Dir()
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			return failf("could not find home directory: %v", err)
 		}
 
 		// search config in home directory with name ".peridotctl" (without extension).
@@ -84,20 +157,146 @@ func initConfig() {
 	// read the config file if we know of one
 	err := viper.ReadInConfig()
 	if err == nil {
-		fmt.Println("Reading from config file: ", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Reading from config file: ", viper.ConfigFileUsed())
 	}
+
+	// flags and config are both read now, so pick up their values
+	address = viper.GetString("address")
+	outputFormat = viper.GetString("output")
+	if outputFormat != outputText && outputFormat != outputJSON {
+		bad := outputFormat
+		outputFormat = outputText
+		return usageErrorf("invalid output format %q: use text or json", bad)
+	}
+	if timeout, err = getTimeout("timeout"); err != nil {
+		return err
+	}
+	if rpcTimeout, err = getTimeout("rpc-timeout"); err != nil {
+		return err
+	}
+	if dialTimeout, err = getTimeout("dial-timeout"); err != nil {
+		return err
+	}
+	retries = viper.GetInt("retries")
+	retryBackoff = viper.GetDuration("retry-backoff")
+	waitForReady = viper.GetBool("wait-for-ready")
+
+	return dialServer()
+}
+
+// getTimeout gets the timeout setting with the given name from flags
+// or config, returning a usage error if it isn't valid.
+func getTimeout(name string) (time.Duration, error) {
+	d, err := config.ParseTimeout(viper.GetString(name))
+	if err != nil {
+		return 0, usageErrorf("--%s: %v", name, err)
+	}
+	return d, nil
 }
 
-func dialServer() {
-	// connect to server
+func dialServer() error {
+	// the shell runs each command through rootCmd.Execute, and keeps
+	// using the connection it started with
+	if cl != nil {
+		return nil
+	}
+
+	if recordFile != "" && replayFile != "" {
+		return usageErrorf("cannot use --record and --replay together")
+	}
+
+	level := verbosity
+	if debug {
+		level = client.LogPayloads
+	}
+
+	if retries < 0 {
+		return usageErrorf("invalid number of retries: %d", retries)
+	}
+	opts := client.Options{
+		Address: address,
+		Retry: client.RetryPolicy{
+			Retries:        retries,
+			InitialBackoff: retryBackoff,
+			MaxBackoff:     client.DefaultRetryPolicy.MaxBackoff,
+		},
+		WaitForReady: waitForReady,
+		DialTimeout:  dialTimeout,
+		CallTimeout:  rpcTimeout,
+	}
+
+	interceptors := []grpc.UnaryClientInterceptor{}
+	if level > 0 {
+		interceptors = append(interceptors, client.LoggingInterceptor(level, debugf))
+	}
+
+	if replayFile != "" {
+		debugf("replaying calls from %s", replayFile)
+		sess, err := client.LoadSession(replayFile)
+		if err != nil {
+			return exitErrorf(exitValidation, "could not load recorded session: %v", err)
+		}
+		opts.DialOptions = []grpc.DialOption{grpc.WithChainUnaryInterceptor(interceptors...)}
+		cl, err = client.NewReplay(sess, opts)
+		if err != nil {
+			return failf("could not replay recorded session: %v", err)
+		}
+		return nil
+	}
+
+	if recordFile != "" {
+		recorder = client.NewRecorder("peridotctl " + strings.Join(os.Args[1:], " "))
+		interceptors = append(interceptors, recorder.Interceptor())
+	}
+
+	// dialing doesn't wait for the server, so it is fine to do this
+	// even for commands that never talk to the controller
+	debugf("dialing peridot controller at %s", address)
+	opts.DialOptions = []grpc.DialOption{grpc.WithChainUnaryInterceptor(interceptors...)}
 	var err error
-	conn, err = grpc.Dial(address, grpc.WithInsecure())
+	cl, err = client.New(opts)
 	if err != nil {
-		log.Printf("error dialing peridot controller: %v", err)
-		return
+		return failf("error dialing peridot controller at %s: %v", address, err)
 	}
 
 	// NOTE: each command must close the connection itself when
 	// it is done. We cannot defer the Close() here.
-	c = pbc.NewControllerClient(conn)
+	return nil
+}
+
+// nowFunc returns the current time, for job set ages and elapsed
+// times.
+var nowFunc = time.Now
+
+// SetNowFunc replaces the function that gives the current time, so that
+// tests get the same output on every run.
+func SetNowFunc(f func() time.Time) {
+	nowFunc = f
+}
+
+// debugf logs a message on stderr if -v or --debug was given.
+func debugf(format string, v ...interface{}) {
+	if verbosity > 0 || debug {
+		log.Printf("debug: "+format, v...)
+	}
+}
+
+// closeConn closes the connection to the controller when a command is
+// done with it, unless it is being kept open for the shell.
+func closeConn() {
+	if !inShell {
+		cl.Close()
+		saveRecording()
+	}
+}
+
+// saveRecording writes the calls recorded so far to the --record file,
+// if there is one.
+func saveRecording() {
+	if recorder == nil {
+		return
+	}
+	if err := recorder.WriteFile(recordFile); err != nil {
+		log.Printf("could not save recorded calls: %v", err)
+	}
 }
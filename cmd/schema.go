@@ -0,0 +1,33 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/swinslow/peridotctl/pkg/parser"
+)
+
+func init() {
+	var cmdSchema = &cobra.Command{
+		Use:   "schema",
+		Short: "Print JSON Schema for YAML files",
+		Long: `Print the JSON Schema for the YAML file format used by
+peridotctl apply. Editors can use it to provide completion and
+inline validation.
+
+Format: peridotctl schema > peridotctl.schema.json`,
+		Args: cobra.NoArgs,
+		RunE: schema,
+	}
+	rootCmd.AddCommand(cmdSchema)
+}
+
+func schema(cmd *cobra.Command, args []string) error {
+	defer closeConn()
+
+	fmt.Print(parser.SchemaJSON)
+	return nil
+}
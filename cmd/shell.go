@@ -0,0 +1,260 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/chzyer/readline"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func init() {
+	var cmdShell = &cobra.Command{
+		Use:   "shell",
+		Short: "Run interactive shell",
+		Long: `Run an interactive shell for entering peridotctl commands,
+keeping the connection to the peridot controller open between them.
+Commands are entered without the leading "peridotctl", and Tab
+completes command names, flags, agent names, job set template names and
+job set IDs. Connection flags such as --address only take effect when
+starting the shell.
+
+Enter "exit" or press Ctrl-D to leave the shell. History is saved in
+$HOME/.peridotctl_history.`,
+		Args: cobra.NoArgs,
+		RunE: shell,
+	}
+	rootCmd.AddCommand(cmdShell)
+}
+
+func shell(cmd *cobra.Command, args []string) error {
+	inShell = true
+	defer func() {
+		inShell = false
+		closeConn()
+	}()
+
+	historyFile := ""
+	if home, err := homedir.Dir(); err == nil {
+		historyFile = filepath.Join(home, ".peridotctl_history")
+	}
+
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:          "peridotctl> ",
+		HistoryFile:     historyFile,
+		AutoComplete:    shellCompleter{},
+		InterruptPrompt: "^C",
+		EOFPrompt:       "exit",
+	})
+	if err != nil {
+		return failf("could not start shell: %v", err)
+	}
+	defer rl.Close()
+
+	// write log messages through readline so they don't garble the prompt
+	log.SetOutput(rl.Stderr())
+
+	// each command starts with the flags as they were for the shell
+	saved := map[*pflag.Flag]flagState{}
+	saveFlags(rootCmd, saved)
+
+	for {
+		line, err := rl.Readline()
+		if err == readline.ErrInterrupt {
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+
+		args, err := splitShellLine(line)
+		if err != nil {
+			log.Printf("%v", err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "exit" || args[0] == "quit" {
+			break
+		}
+		if args[0] == "shell" {
+			log.Printf("already running shell")
+			continue
+		}
+
+		runShellCommand(args, saved)
+	}
+	return nil
+}
+
+// runShellCommand runs one command entered in the shell, reporting any
+// error as Execute would but without exiting.
+func runShellCommand(args []string, saved map[*pflag.Flag]flagState) {
+	restoreFlags(saved)
+	rootCmd.SetArgs(args)
+	sub, err := rootCmd.ExecuteC()
+	if err != nil {
+		reportFailure(sub, err)
+	}
+	// initConfig turns off usage information once the command line has
+	// been parsed; turn it back on for the next command
+	if sub != nil {
+		sub.SilenceUsage = false
+	}
+}
+
+// flagState is the saved value of a flag.
+type flagState struct {
+	value   string
+	slice   []string
+	changed bool
+}
+
+// saveFlags records the current value of every flag for cmd and its
+// subcommands.
+func saveFlags(cmd *cobra.Command, saved map[*pflag.Flag]flagState) {
+	save := func(f *pflag.Flag) {
+		st := flagState{value: f.Value.String(), changed: f.Changed}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			st.slice = sv.GetSlice()
+		}
+		saved[f] = st
+	}
+	cmd.Flags().VisitAll(save)
+	cmd.PersistentFlags().VisitAll(save)
+
+	for _, sub := range cmd.Commands() {
+		saveFlags(sub, saved)
+	}
+}
+
+// restoreFlags sets every flag back to the value recorded by saveFlags,
+// so that flags given for one shell command don't carry over to the
+// next.
+func restoreFlags(saved map[*pflag.Flag]flagState) {
+	for f, st := range saved {
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			sv.Replace(st.slice)
+		} else {
+			f.Value.Set(st.value)
+		}
+		f.Changed = st.changed
+	}
+}
+
+// splitShellLine splits a line entered in the shell into arguments,
+// separated by spaces, treating text in single or double quotes as
+// part of one argument.
+func splitShellLine(line string) ([]string, error) {
+	args := []string{}
+	var cur strings.Builder
+	inArg := false
+	var quote rune
+
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// shellCompleter completes commands, flags and arguments in the shell.
+type shellCompleter struct{}
+
+// Do implements readline.AutoCompleter. It returns the remaining text
+// of each candidate that begins with the word being completed, along
+// with the length of that word.
+func (shellCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	text := string(line[:pos])
+	words := strings.Fields(text)
+	partial := ""
+	if len(words) > 0 && !strings.HasSuffix(text, " ") {
+		partial = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+
+	// find the command given by the complete words so far
+	cmd := rootCmd
+	args := []string{}
+	for _, w := range words {
+		if sub := findSubcommand(cmd, w); sub != nil && len(args) == 0 {
+			cmd = sub
+		} else if !strings.HasPrefix(w, "-") {
+			args = append(args, w)
+		}
+	}
+
+	candidates := []string{}
+	if strings.HasPrefix(partial, "-") {
+		addFlag := func(f *pflag.Flag) {
+			if !f.Hidden {
+				candidates = append(candidates, "--"+f.Name)
+			}
+		}
+		cmd.Flags().VisitAll(addFlag)
+		cmd.InheritedFlags().VisitAll(addFlag)
+	} else if len(args) == 0 && cmd.HasAvailableSubCommands() {
+		for _, sub := range cmd.Commands() {
+			if sub.IsAvailableCommand() {
+				candidates = append(candidates, sub.Name())
+			}
+		}
+		if cmd == rootCmd {
+			candidates = append(candidates, "exit")
+		}
+	} else if cmd.ValidArgsFunction != nil {
+		candidates, _ = cmd.ValidArgsFunction(cmd, args, partial)
+	} else {
+		candidates = cmd.ValidArgs
+	}
+
+	completions := [][]rune{}
+	for _, cand := range candidates {
+		if strings.HasPrefix(cand, partial) {
+			completions = append(completions, []rune(cand[len(partial):]+" "))
+		}
+	}
+	return completions, len([]rune(partial))
+}
+
+func findSubcommand(cmd *cobra.Command, name string) *cobra.Command {
+	for _, sub := range cmd.Commands() {
+		if sub.Name() == name || sub.HasAlias(name) {
+			return sub
+		}
+	}
+	return nil
+}
@@ -4,14 +4,13 @@ package cmd
 
 import (
 	"fmt"
-	"log"
 	"strings"
 
 	"github.com/spf13/cobra"
 
 	pbc "github.com/swinslow/peridot-core/pkg/controller"
 	"github.com/swinslow/peridotctl/internal/config"
-	"github.com/swinslow/peridotctl/internal/outputfmt"
+	"github.com/swinslow/peridotctl/pkg/outputfmt"
 )
 
 func init() {
@@ -30,27 +29,79 @@ func init() {
 		Short: "Get all registered job set templates",
 		Long: `Get information about all job set templates registered with the
 		peridot controller.`,
-		Run: templateList,
+		RunE: templateList,
 	}
 	cmdTemplate.AddCommand(cmdTemplateList)
+
+	var cmdTemplateGraph = &cobra.Command{
+		Use:   "graph",
+		Short: "Draw job set template as a graph",
+		Long: `Draw the steps of a registered job set template as a
+Graphviz DOT or Mermaid flowchart, expanding any jobset steps into the
+steps of the templates they refer to.
+
+Format: peridotctl template graph [--format dot|mermaid] NAME
+
+	NAME: Name of job set template`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeFirstArg("templates", lookupTemplateNames),
+		RunE:              templateGraph,
+	}
+	cmdTemplateGraph.Flags().StringVar(&graphFormat, "format", outputfmt.GraphDOT, "graph format (dot or mermaid)")
+	cmdTemplate.AddCommand(cmdTemplateGraph)
 }
 
-func templateList(cmd *cobra.Command, args []string) {
+func templateList(cmd *cobra.Command, args []string) error {
 	ctx, cancel := config.GetContext(timeout)
 	defer cancel()
-	defer conn.Close()
+	defer closeConn()
 
-	resp, err := c.GetAllJobSetTemplates(ctx, &pbc.GetAllJobSetTemplatesReq{})
+	jsts, err := cl.GetAllJobSetTemplates(ctx)
 	if err != nil {
-		log.Fatalf("could not get job set templates: %v", err)
+		return failf("could not get job set templates: %v", err)
 	}
 
 	fmt.Printf("Registered job set templates:\n\n")
 
-	for _, jst := range resp.Jsts {
+	for _, jst := range jsts {
 		fmt.Printf("  - name: %s\n", jst.Name)
 		fmt.Printf("    steps:\n")
 		fmt.Println(strings.Join(outputfmt.ConvertStepTemplates(jst.Steps, 4), "\n"))
 	}
 	fmt.Printf("\n")
+	return nil
+}
+
+func templateGraph(cmd *cobra.Command, args []string) error {
+	ctx, cancel := config.GetContext(timeout)
+	defer cancel()
+	defer closeConn()
+
+	name := args[0]
+
+	jsts, err := cl.GetAllJobSetTemplates(ctx)
+	if err != nil {
+		return failf("could not get job set templates: %v", err)
+	}
+
+	templates := map[string]*pbc.JobSetTemplate{}
+	for _, jst := range jsts {
+		templates[jst.Name] = jst
+	}
+
+	if _, ok := templates[name]; !ok {
+		return exitErrorf(exitNotFound, "job set template %s not found", name)
+	}
+
+	g, err := outputfmt.TemplateGraph(name, templates)
+	if err != nil {
+		return failf("could not graph job set template %s: %v", name, err)
+	}
+
+	out, err := g.Render(graphFormat)
+	if err != nil {
+		return usageErrorf("could not graph job set template %s: %v", name, err)
+	}
+	fmt.Print(out)
+	return nil
 }
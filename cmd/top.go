@@ -0,0 +1,422 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/spf13/cobra"
+
+	pbc "github.com/swinslow/peridot-core/pkg/controller"
+	"github.com/swinslow/peridotctl/internal/config"
+	"github.com/swinslow/peridotctl/pkg/client"
+	"github.com/swinslow/peridotctl/pkg/outputfmt"
+)
+
+var topInterval time.Duration
+
+func init() {
+	var cmdTop = &cobra.Command{
+		Use:   "top",
+		Short: "Show interactive dashboard",
+		Long: `Show a full-screen dashboard of the peridot controller's
+status, registered agents and job set templates, and job sets, refreshed
+on an interval.
+
+Keys:
+	Enter:  show steps for the selected job set
+	f:      in job set view, toggle following (live refresh)
+	s:      start a new job set from a template
+	r:      refresh now
+	Esc:    go back
+	q:      quit`,
+		Args: cobra.NoArgs,
+		RunE: top,
+	}
+	cmdTop.Flags().DurationVar(&topInterval, "interval", 2*time.Second, "how often to refresh")
+	rootCmd.AddCommand(cmdTop)
+}
+
+// topSnapshot is the data shown on the dashboard, as of one refresh.
+type topSnapshot struct {
+	status    *pbc.GetStatusResp
+	agents    []*pbc.AgentConfig
+	templates []*pbc.JobSetTemplate
+	jobSets   []*pbc.JobSetDetails
+	err       error
+	at        time.Time
+}
+
+// topUI holds the dashboard's widgets and state. Its fields other than
+// ctx and the widgets are only accessed from the tview event loop.
+type topUI struct {
+	// ctx is cancelled when the dashboard exits; each call to the
+	// controller gets its own timeout within it.
+	ctx       context.Context
+	app       *tview.Application
+	pages     *tview.Pages
+	header    *tview.TextView
+	agents    *tview.TextView
+	templates *tview.TextView
+	jobSets   *tview.Table
+	detail    *tview.TextView
+	footer    *tview.TextView
+	snap      topSnapshot
+	detailID  uint64
+	following bool
+}
+
+func top(cmd *cobra.Command, args []string) error {
+	defer closeConn()
+
+	if topInterval <= 0 {
+		return usageErrorf("invalid refresh interval: %s", topInterval)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ui := newTopUI(ctx)
+
+	// the terminal is in raw mode, so Ctrl-C arrives as a key press;
+	// stop cleanly if killed as well, so the terminal gets restored
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		select {
+		case <-sigs:
+			ui.app.Stop()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(topInterval)
+	done := make(chan struct{})
+	go ui.refresh()
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ui.refresh()
+			}
+		}
+	}()
+
+	err := ui.app.Run()
+	ticker.Stop()
+	close(done)
+	if err != nil {
+		return failf("could not run dashboard: %v", err)
+	}
+	return nil
+}
+
+func newTopUI(ctx context.Context) *topUI {
+	ui := &topUI{
+		ctx:       ctx,
+		app:       tview.NewApplication(),
+		pages:     tview.NewPages(),
+		header:    tview.NewTextView().SetDynamicColors(true),
+		agents:    tview.NewTextView().SetDynamicColors(true),
+		templates: tview.NewTextView().SetDynamicColors(true),
+		jobSets:   tview.NewTable().SetSelectable(true, false).SetFixed(1, 0),
+		detail:    tview.NewTextView().SetDynamicColors(true),
+		footer:    tview.NewTextView().SetDynamicColors(true),
+	}
+
+	ui.header.SetBorder(true).SetTitle(" controller ")
+	ui.agents.SetBorder(true).SetTitle(" agents ")
+	ui.templates.SetBorder(true).SetTitle(" job set templates ")
+	ui.jobSets.SetBorder(true).SetTitle(" job sets ")
+	ui.detail.SetBorder(true)
+	ui.setFooter("")
+
+	ui.jobSets.SetSelectedFunc(func(row, column int) {
+		if row < 1 || row > len(ui.snap.jobSets) {
+			return
+		}
+		ui.showDetail(ui.snap.jobSets[row-1].JobSetID)
+	})
+
+	left := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(ui.agents, 0, 1, false).
+		AddItem(ui.templates, 0, 1, false)
+	body := tview.NewFlex().
+		AddItem(left, 0, 1, false).
+		AddItem(ui.jobSets, 0, 2, true)
+	main := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(ui.header, 6, 0, false).
+		AddItem(body, 0, 1, true)
+
+	ui.pages.AddPage("main", main, true, true)
+	ui.pages.AddPage("detail", ui.detail, true, false)
+
+	root := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(ui.pages, 0, 1, true).
+		AddItem(ui.footer, 1, 0, false)
+	ui.app.SetRoot(root, true).SetInputCapture(ui.handleKey)
+
+	return ui
+}
+
+func (ui *topUI) handleKey(ev *tcell.EventKey) *tcell.EventKey {
+	page, _ := ui.pages.GetFrontPage()
+	if page == "start" {
+		// let the form have all keys
+		return ev
+	}
+
+	switch {
+	case ev.Key() == tcell.KeyEscape:
+		if page != "main" {
+			ui.showMain()
+			return nil
+		}
+	case ev.Rune() == 'q':
+		ui.app.Stop()
+		return nil
+	case ev.Rune() == 'r':
+		go ui.refresh()
+		return nil
+	case ev.Rune() == 's':
+		ui.showStartForm()
+		return nil
+	case ev.Rune() == 'f' && page == "detail":
+		ui.following = !ui.following
+		ui.updateDetail()
+		return nil
+	}
+	return ev
+}
+
+func (ui *topUI) setFooter(msg string) {
+	help := "[::b]Enter[::-] steps  [::b]s[::-] start job set  [::b]r[::-] refresh  [::b]Esc[::-] back  [::b]q[::-] quit"
+	if page, _ := ui.pages.GetFrontPage(); page == "detail" {
+		help = "[::b]f[::-] follow  [::b]Esc[::-] back  [::b]q[::-] quit"
+	}
+	if msg != "" {
+		help = tview.Escape(msg) + "  |  " + help
+	}
+	ui.footer.SetText(help)
+}
+
+// callContext returns a context for one refresh or other action,
+// which times out after the --timeout value, if set, and is cancelled
+// when the dashboard exits.
+func (ui *topUI) callContext() (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ui.ctx, timeout)
+	}
+	return context.WithCancel(ui.ctx)
+}
+
+// refresh fetches a new snapshot from the controller and then updates
+// the widgets from the event loop.
+func (ui *topUI) refresh() {
+	ctx, cancel := ui.callContext()
+	defer cancel()
+
+	snap := fetchTopSnapshot(ctx)
+	ui.app.QueueUpdateDraw(func() {
+		ui.snap = snap
+		ui.updateMain()
+		if page, _ := ui.pages.GetFrontPage(); page == "detail" && ui.following {
+			ui.updateDetail()
+		}
+	})
+}
+
+func fetchTopSnapshot(ctx context.Context) topSnapshot {
+	snap := topSnapshot{at: nowFunc()}
+
+	snap.status, snap.err = cl.GetStatus(ctx)
+	if snap.err != nil {
+		return snap
+	}
+
+	snap.agents, snap.err = cl.GetAllAgents(ctx)
+	if snap.err != nil {
+		return snap
+	}
+
+	snap.templates, snap.err = cl.GetAllJobSetTemplates(ctx)
+	if snap.err != nil {
+		return snap
+	}
+
+	snap.jobSets, snap.err = cl.GetAllJobSets(ctx)
+	if snap.err != nil {
+		return snap
+	}
+	// show newest job sets first
+	sort.Slice(snap.jobSets, func(i, j int) bool {
+		return snap.jobSets[i].JobSetID > snap.jobSets[j].JobSetID
+	})
+
+	return snap
+}
+
+func (ui *topUI) updateMain() {
+	snap := ui.snap
+	if snap.err != nil {
+		ui.header.SetText(fmt.Sprintf("[red]error: %s[-]\nlast refresh: %s", tview.Escape(snap.err.Error()), snap.at.Format(time.Kitchen)))
+		return
+	}
+
+	st := snap.status
+	ui.header.SetText(fmt.Sprintf("status: [::b]%s[::-]   health: [::b]%s[::-]   last refresh: %s\noutput: %s\nerrors: %s",
+		st.RunStatus.String(), st.HealthStatus.String(), snap.at.Format(time.Kitchen),
+		tview.Escape(st.OutputMsg), tview.Escape(st.ErrorMsg)))
+
+	agentLines := []string{}
+	for _, a := range snap.agents {
+		agentLines = append(agentLines, tview.Escape(fmt.Sprintf("%s (%s) %s:%d", a.Name, a.Type, a.Url, a.Port)))
+	}
+	ui.agents.SetText(strings.Join(agentLines, "\n"))
+
+	templateLines := []string{}
+	for _, jst := range snap.templates {
+		templateLines = append(templateLines, tview.Escape(fmt.Sprintf("%s (%d steps)", jst.Name, len(jst.Steps))))
+	}
+	ui.templates.SetText(strings.Join(templateLines, "\n"))
+
+	row, _ := ui.jobSets.GetSelection()
+	ui.jobSets.Clear()
+	for col, h := range []string{"ID", "TEMPLATE", "STATUS", "HEALTH", "STARTED", "DURATION"} {
+		ui.jobSets.SetCell(0, col, tview.NewTableCell(h).SetSelectable(false).SetAttributes(tcell.AttrBold))
+	}
+	topts := outputfmt.TimeOptions{Format: outputfmt.TimeRelative, Now: snap.at}
+	for i, jsd := range snap.jobSets {
+		cells := []string{
+			fmt.Sprintf("%d", jsd.JobSetID),
+			jsd.TemplateName,
+			jsd.St.RunStatus.String(),
+			jsd.St.HealthStatus.String(),
+			outputfmt.FormatTime(jsd.St.TimeStarted, topts),
+			outputfmt.FormatDuration(jsd.St.TimeStarted, jsd.St.TimeFinished, snap.at),
+		}
+		for col, text := range cells {
+			ui.jobSets.SetCell(i+1, col, tview.NewTableCell(tview.Escape(text)).SetExpansion(1))
+		}
+	}
+	if row < 1 {
+		row = 1
+	}
+	ui.jobSets.Select(row, 0)
+}
+
+func (ui *topUI) showMain() {
+	ui.pages.SwitchToPage("main")
+	ui.setFooter("")
+}
+
+func (ui *topUI) showDetail(id uint64) {
+	ui.detailID = id
+	ui.following = true
+	ui.pages.SwitchToPage("detail")
+	ui.setFooter("")
+	ui.updateDetail()
+}
+
+// updateDetail fetches the job set being shown in the detail page, and
+// then redraws it from the event loop.
+func (ui *topUI) updateDetail() {
+	id, following := ui.detailID, ui.following
+	go func() {
+		ctx, cancel := ui.callContext()
+		defer cancel()
+
+		getJobSet := jobSetGetter(ctx)
+		lines := []string{}
+		jsd, err := getJobSet(id)
+		if err != nil {
+			lines = append(lines, fmt.Sprintf("could not get job set with ID %d: %v", id, err))
+		} else {
+			lines = outputfmt.ConvertJobSetTree(jsd, getJobSet, outputfmt.TreeOptions{Now: nowFunc()})
+		}
+
+		title := fmt.Sprintf(" job set %d ", id)
+		if following {
+			title += "(following) "
+		}
+		ui.app.QueueUpdateDraw(func() {
+			if ui.detailID != id {
+				return
+			}
+			ui.detail.SetTitle(title)
+			ui.detail.SetText(tview.Escape(strings.Join(lines, "\n")))
+		})
+	}()
+}
+
+func (ui *topUI) showStartForm() {
+	names := []string{}
+	for _, jst := range ui.snap.templates {
+		names = append(names, jst.Name)
+	}
+	if len(names) == 0 {
+		ui.setFooter("no job set templates registered")
+		return
+	}
+
+	name, cfgStr := names[0], ""
+	form := tview.NewForm()
+	form.AddDropDown("Template", names, 0, func(option string, index int) {
+		name = option
+	})
+	form.AddInputField("Configs (key1:value1;...)", "", 50, nil, func(text string) {
+		cfgStr = text
+	})
+	form.AddButton("Start", func() {
+		ui.pages.RemovePage("start")
+		ui.startJobSet(name, cfgStr)
+	})
+	form.AddButton("Cancel", func() {
+		ui.pages.RemovePage("start")
+		ui.showMain()
+	})
+	form.SetCancelFunc(func() {
+		ui.pages.RemovePage("start")
+		ui.showMain()
+	})
+	form.SetBorder(true).SetTitle(" start job set ")
+
+	ui.pages.AddPage("start", form, true, true)
+}
+
+func (ui *topUI) startJobSet(name string, cfgStr string) {
+	cfgs := config.ExtractKVs(cfgStr)
+
+	go func() {
+		ctx, cancel := ui.callContext()
+		defer cancel()
+
+		jobSetID, err := cl.StartJobSet(ctx, name, cfgs)
+		var rerr *client.RejectedError
+		ui.app.QueueUpdateDraw(func() {
+			if errors.As(err, &rerr) {
+				ui.showMain()
+				ui.setFooter(fmt.Sprintf("error starting job set for template %s: %s", name, rerr.Msg))
+			} else if err != nil {
+				ui.showMain()
+				ui.setFooter(fmt.Sprintf("could not start job set for template %s: %v", name, err))
+			} else {
+				ui.showDetail(jobSetID)
+				ui.setFooter(fmt.Sprintf("job set started for template %s with ID %d", name, jobSetID))
+			}
+		})
+		ui.refresh()
+	}()
+}
@@ -4,17 +4,83 @@ package config
 
 import (
 	"context"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
-// GetContext gets the context and cancellation for a gRPC call.
-func GetContext(timeout int) (context.Context, context.CancelFunc) {
+// GetContext gets the context and cancellation for a gRPC call. The
+// context is also cancelled if peridotctl is interrupted with SIGINT
+// or SIGTERM, so that calls in progress stop cleanly.
+// A timeout of zero means no timeout.
+func GetContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
 	if timeout > 0 {
-		return context.WithTimeout(context.Background(), time.Second*time.Duration(timeout))
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
 	} else {
-		return context.WithCancel(context.Background())
+		ctx, cancel = context.WithCancel(context.Background())
 	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigs:
+			cancel()
+		case <-ctx.Done():
+		}
+		// after this, a second Ctrl-C exits straight away as usual
+		signal.Stop(sigs)
+	}()
+
+	return ctx, cancel
+}
+
+// maxTimeoutSeconds is the largest number of seconds that fits in a
+// time.Duration, leaving room for rounding.
+const maxTimeoutSeconds = float64(math.MaxInt64/int64(time.Second)) - 1
+
+// ParseTimeout parses a timeout given as a Go duration string, such as
+// "30s" or "1m30s", or as a plain number of seconds as in earlier
+// versions of peridotctl. It returns an error for negative timeouts,
+// and for numbers of seconds that aren't finite or are too large to be
+// a time.Duration.
+func ParseTimeout(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	var d time.Duration
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		if math.IsNaN(secs) || math.IsInf(secs, 0) {
+			return 0, fmt.Errorf("invalid timeout %q: must be a finite number of seconds", s)
+		}
+		if secs > maxTimeoutSeconds {
+			return 0, fmt.Errorf("invalid timeout %q: cannot be more than %d seconds", s, int64(maxTimeoutSeconds))
+		}
+		if secs < 0 {
+			return 0, fmt.Errorf("invalid timeout %q: cannot be negative", s)
+		}
+		d = time.Duration(secs * float64(time.Second))
+	} else {
+		d, err = time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timeout %q: use a duration such as 30s or 2m, or a number of seconds", s)
+		}
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid timeout %q: cannot be negative", s)
+	}
+	return d, nil
+}
+
+// Interrupted returns true if ctx was cancelled, rather than timing out
+// or still being active.
+func Interrupted(ctx context.Context) bool {
+	return ctx.Err() == context.Canceled
 }
 
 // ExtractKVs extracts a series of semicolon-separated key:value pairs
@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package config
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimeout(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr string
+	}{
+		{"0", 0, ""},
+		{"10", 10 * time.Second, ""},
+		{" 10 ", 10 * time.Second, ""},
+		{"1.5", 1500 * time.Millisecond, ""},
+		{"30s", 30 * time.Second, ""},
+		{"1m30s", 90 * time.Second, ""},
+		{"250ms", 250 * time.Millisecond, ""},
+		{"0s", 0, ""},
+		{"-1", 0, "cannot be negative"},
+		{"-1s", 0, "cannot be negative"},
+		{"NaN", 0, "must be a finite number of seconds"},
+		{"Inf", 0, "must be a finite number of seconds"},
+		{"-Inf", 0, "must be a finite number of seconds"},
+		{"1e300", 0, "cannot be more than 9223372035 seconds"},
+		{"", 0, "use a duration such as 30s or 2m"},
+		{"soon", 0, "use a duration such as 30s or 2m"},
+		{"10 s", 0, "use a duration such as 30s or 2m"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			got, err := ParseTimeout(tc.in)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("got %v, %v, want error containing %q", got, err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTimeout: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGetContext(t *testing.T) {
+	tests := []struct {
+		name         string
+		timeout      time.Duration
+		wantDeadline bool
+	}{
+		{"no timeout", 0, false},
+		{"negative timeout", -time.Second, false},
+		{"timeout", time.Minute, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			before := time.Now()
+			ctx, cancel := GetContext(tc.timeout)
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			if ok != tc.wantDeadline {
+				t.Fatalf("got deadline %v, want deadline: %v", ok, tc.wantDeadline)
+			}
+			if ok && (deadline.Before(before.Add(tc.timeout)) || deadline.After(time.Now().Add(tc.timeout))) {
+				t.Errorf("got deadline %v, want %v from now", deadline, tc.timeout)
+			}
+			if ctx.Err() != nil {
+				t.Errorf("got error %v before cancel", ctx.Err())
+			}
+
+			cancel()
+			if !Interrupted(ctx) {
+				t.Errorf("got Interrupted false after cancel, want true")
+			}
+		})
+	}
+}
+
+func TestInterruptedTimeout(t *testing.T) {
+	ctx, cancel := GetContext(time.Millisecond)
+	defer cancel()
+
+	<-ctx.Done()
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Errorf("got error %v, want deadline exceeded", ctx.Err())
+	}
+	if Interrupted(ctx) {
+		t.Errorf("got Interrupted true after timeout, want false")
+	}
+}
+
+func TestExtractKVs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string]string
+	}{
+		{"", map[string]string{}},
+		{"a:1", map[string]string{"a": "1"}},
+		{"a:1;b:2", map[string]string{"a": "1", "b": "2"}},
+		{"a:1;a:2", map[string]string{"a": "2"}},
+		{"url:http://x:80", map[string]string{"url": "http://x:80"}},
+		{"a:1;nocolon;b:", map[string]string{"a": "1", "b": ""}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			if got := ExtractKVs(tc.in); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	pbc "github.com/swinslow/peridot-core/pkg/controller"
+	"github.com/swinslow/peridotctl/pkg/parser"
+)
+
+// ApplyResult is the outcome of registering one object from a manifest.
+type ApplyResult struct {
+	// Kind is "agent" or "job set template".
+	Kind string
+	// Name is the object's name.
+	Name string
+	// Err is nil if the object was registered, or a *RejectedError
+	// if the controller refused it.
+	Err error
+}
+
+// InterruptedError is returned by ApplyManifest when its context is
+// done before every object in the manifest has been submitted.
+type InterruptedError struct {
+	// Err is the context's error.
+	Err error
+	// InFlight describes the object that was being submitted, such as
+	// "agent foo", or is empty if none was. The controller may or may
+	// not have registered it.
+	InFlight string
+	// NotSubmitted is how many objects were never submitted.
+	NotSubmitted int
+}
+
+func (e *InterruptedError) Error() string {
+	msg := fmt.Sprintf("stopped applying (%v)", e.Err)
+	if e.InFlight != "" {
+		msg += fmt.Sprintf(" while submitting %s, which may or may not have been registered", e.InFlight)
+	}
+	return msg + fmt.Sprintf("; %d more objects were not submitted", e.NotSubmitted)
+}
+
+// ApplyManifest registers the agents and job set templates in a parsed
+// manifest. Agents are registered first, and then templates in
+// dependency order, so that a template referenced by another
+// template's jobset step is registered before it.
+//
+// It returns a result for each object that was submitted. Objects that
+// the controller refuses are reported in their results, and applying
+// continues. Any other error, such as the controller being
+// unreachable, stops applying and is returned along with the results
+// so far. If ctx is done part way through, the error is an
+// *InterruptedError saying what was and wasn't submitted.
+func (cl *Client) ApplyManifest(ctx context.Context, req *parser.PeridotReq) ([]ApplyResult, error) {
+	results := []ApplyResult{}
+	total := len(req.Agents) + len(req.Templates)
+
+	// failed returns the error for a failed submission of the named
+	// object, and the results so far
+	failed := func(kind string, name string, err error) ([]ApplyResult, error) {
+		if ctx.Err() != nil {
+			return results, &InterruptedError{
+				Err:          ctx.Err(),
+				InFlight:     kind + " " + name,
+				NotSubmitted: total - len(results) - 1,
+			}
+		}
+		// keep err, so that callers can tell why
+		return results, fmt.Errorf("could not add %s %s: %w", kind, name, err)
+	}
+
+	// interrupted returns the error for ctx being done between
+	// submissions
+	interrupted := func() error {
+		return &InterruptedError{Err: ctx.Err(), NotSubmitted: total - len(results)}
+	}
+
+	for _, agent := range req.Agents {
+		if ctx.Err() != nil {
+			return results, interrupted()
+		}
+		err := cl.AddAgent(ctx, BuildAgentConfig(agent))
+		if err != nil && !errors.As(err, new(*RejectedError)) {
+			return failed("agent", agent.Name, err)
+		}
+		results = append(results, ApplyResult{Kind: "agent", Name: agent.Name, Err: err})
+	}
+
+	ordered, err := parser.OrderJobSetTemplates(req.Templates)
+	if err != nil {
+		return results, err
+	}
+
+	for _, template := range ordered {
+		jst, err := BuildJobSetTemplate(template)
+		if err != nil {
+			return results, err
+		}
+
+		if ctx.Err() != nil {
+			return results, interrupted()
+		}
+		err = cl.AddJobSetTemplate(ctx, jst)
+		if err != nil && !errors.As(err, new(*RejectedError)) {
+			return failed("job set template", template.Name, err)
+		}
+		results = append(results, ApplyResult{Kind: "job set template", Name: template.Name, Err: err})
+	}
+
+	return results, nil
+}
+
+// BuildAgentConfig converts a parsed agent into its protobuf version.
+func BuildAgentConfig(agent parser.PeridotAgent) *pbc.AgentConfig {
+	// build configs into AgentKV list
+	kvs := []*pbc.AgentConfig_AgentKV{}
+	for k, v := range agent.Configs {
+		kv := pbc.AgentConfig_AgentKV{Key: k, Value: v}
+		kvs = append(kvs, &kv)
+	}
+
+	return &pbc.AgentConfig{
+		Name: agent.Name,
+		Url:  agent.URL,
+		Port: agent.Port,
+		Type: agent.TypeStr,
+		Kvs:  kvs,
+	}
+}
+
+// BuildJobSetTemplate converts a parsed job set template into its
+// protobuf version.
+func BuildJobSetTemplate(template parser.PeridotJobSetTemplate) (*pbc.JobSetTemplate, error) {
+	steps, err := buildStepTemplates(template.Steps)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pbc.JobSetTemplate{
+		Name:  template.Name,
+		Steps: steps,
+	}, nil
+}
+
+func buildStepTemplates(jstSteps []parser.PeridotJSTStep) ([]*pbc.StepTemplate, error) {
+	stepTemplates := []*pbc.StepTemplate{}
+
+	for _, jstStep := range jstSteps {
+		newStep := &pbc.StepTemplate{}
+		switch jstStep.TypeStr {
+		case "agent":
+			newStep.S = &pbc.StepTemplate_Agent{
+				Agent: &pbc.StepAgentTemplate{Name: jstStep.Name},
+			}
+			stepTemplates = append(stepTemplates, newStep)
+		case "jobset":
+			newStep.S = &pbc.StepTemplate_Jobset{
+				Jobset: &pbc.StepJobSetTemplate{Name: jstStep.Name},
+			}
+			stepTemplates = append(stepTemplates, newStep)
+		case "concurrent":
+			subSteps, err := buildStepTemplates(jstStep.Steps)
+			if err != nil {
+				return nil, err
+			}
+			newStep.S = &pbc.StepTemplate_Concurrent{
+				Concurrent: &pbc.StepConcurrentTemplate{Steps: subSteps},
+			}
+			stepTemplates = append(stepTemplates, newStep)
+		default:
+			return nil, fmt.Errorf("invalid step type %s in request", jstStep.TypeStr)
+		}
+	}
+
+	return stepTemplates, nil
+}
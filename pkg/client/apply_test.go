@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	pbc "github.com/swinslow/peridot-core/pkg/controller"
+	"github.com/swinslow/peridotctl/pkg/parser"
+)
+
+func TestBuildAgentConfig(t *testing.T) {
+	cfg := BuildAgentConfig(parser.PeridotAgent{
+		Name:    "idsearcher",
+		URL:     "localhost",
+		Port:    9001,
+		TypeStr: "idsearcher",
+		Configs: map[string]string{"mode": "fast"},
+	})
+
+	want := &pbc.AgentConfig{
+		Name: "idsearcher",
+		Url:  "localhost",
+		Port: 9001,
+		Type: "idsearcher",
+		Kvs:  []*pbc.AgentConfig_AgentKV{{Key: "mode", Value: "fast"}},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("got %+v, want %+v", cfg, want)
+	}
+}
+
+func TestBuildJobSetTemplate(t *testing.T) {
+	jst, err := BuildJobSetTemplate(parser.PeridotJobSetTemplate{
+		Name: "scan",
+		Steps: []parser.PeridotJSTStep{
+			{TypeStr: "agent", Name: "idsearcher"},
+			{TypeStr: "concurrent", Steps: []parser.PeridotJSTStep{
+				{TypeStr: "jobset", Name: "tag"},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("BuildJobSetTemplate: %v", err)
+	}
+
+	want := &pbc.JobSetTemplate{
+		Name: "scan",
+		Steps: []*pbc.StepTemplate{
+			{S: &pbc.StepTemplate_Agent{Agent: &pbc.StepAgentTemplate{Name: "idsearcher"}}},
+			{S: &pbc.StepTemplate_Concurrent{Concurrent: &pbc.StepConcurrentTemplate{Steps: []*pbc.StepTemplate{
+				{S: &pbc.StepTemplate_Jobset{Jobset: &pbc.StepJobSetTemplate{Name: "tag"}}},
+			}}}},
+		},
+	}
+	if !reflect.DeepEqual(jst, want) {
+		t.Errorf("got %+v, want %+v", jst, want)
+	}
+}
+
+func TestBuildJobSetTemplateInvalidStep(t *testing.T) {
+	_, err := BuildJobSetTemplate(parser.PeridotJobSetTemplate{
+		Name: "scan",
+		Steps: []parser.PeridotJSTStep{
+			{TypeStr: "concurrent", Steps: []parser.PeridotJSTStep{{TypeStr: "parallel"}}},
+		},
+	})
+	if err == nil || err.Error() != "invalid step type parallel in request" {
+		t.Errorf("got error %v, want invalid step type", err)
+	}
+}
@@ -0,0 +1,212 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+// Package client provides a Client for the peridot controller's gRPC API,
+// wrapping its calls with typed methods and errors.
+package client
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+
+	pbc "github.com/swinslow/peridot-core/pkg/controller"
+)
+
+// Options contains the settings for connecting to a peridot controller.
+type Options struct {
+	// Address is the host and port of the controller's gRPC server.
+	Address string
+	// DialOptions are any additional options to use when dialing,
+	// such as interceptors.
+	DialOptions []grpc.DialOption
+	// Retry says how to retry read calls when the controller is
+	// unavailable. The zero value means calls are not retried.
+	Retry RetryPolicy
+	// WaitForReady makes calls wait for the controller to become
+	// reachable, until their context is done, instead of failing
+	// straight away.
+	WaitForReady bool
+	// DialTimeout is how long calls wait for the connection to the
+	// controller to be ready before failing. Zero means calls don't
+	// wait beyond their own deadline.
+	DialTimeout time.Duration
+	// CallTimeout is the deadline for each call, within the deadline
+	// of the context it is made with, so that every call in a loop
+	// gets the same time. Zero means calls have no deadline of their
+	// own.
+	CallTimeout time.Duration
+}
+
+// Client is a connection to a peridot controller.
+type Client struct {
+	conn *grpc.ClientConn
+	pc   pbc.ControllerClient
+}
+
+// New connects to the peridot controller at opts.Address. Connecting
+// does not wait for the controller to respond, so an unreachable
+// controller is only reported by the first call made.
+func New(opts Options) (*Client, error) {
+	dialOpts := []grpc.DialOption{grpc.WithInsecure()}
+	// retrying goes first, so that other interceptors see each attempt
+	if opts.Retry.Retries > 0 {
+		dialOpts = append(dialOpts, grpc.WithChainUnaryInterceptor(RetryInterceptor(opts.Retry)))
+	}
+	// each attempt gets its own time to connect and to complete
+	if opts.DialTimeout > 0 {
+		dialOpts = append(dialOpts, grpc.WithChainUnaryInterceptor(DialTimeoutInterceptor(opts.DialTimeout)))
+	}
+	if opts.CallTimeout > 0 {
+		dialOpts = append(dialOpts, grpc.WithChainUnaryInterceptor(CallTimeoutInterceptor(opts.CallTimeout)))
+	}
+	if opts.WaitForReady {
+		dialOpts = append(dialOpts, grpc.WithDefaultCallOptions(grpc.WaitForReady(true)))
+	}
+	dialOpts = append(dialOpts, opts.DialOptions...)
+
+	conn, err := grpc.Dial(opts.Address, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromConn(conn), nil
+}
+
+// NewFromConn creates a Client that uses an existing connection.
+func NewFromConn(conn *grpc.ClientConn) *Client {
+	return &Client{conn: conn, pc: pbc.NewControllerClient(conn)}
+}
+
+// Close closes the connection to the controller.
+func (cl *Client) Close() error {
+	return cl.conn.Close()
+}
+
+// Controller returns the underlying gRPC client, for calls that Client
+// does not wrap.
+func (cl *Client) Controller() pbc.ControllerClient {
+	return cl.pc
+}
+
+// GetStatus gets the controller's current status.
+func (cl *Client) GetStatus(ctx context.Context) (*pbc.GetStatusResp, error) {
+	return cl.pc.GetStatus(ctx, &pbc.GetStatusReq{})
+}
+
+// Start asks the controller to start. It returns a *RejectedError if
+// the controller refuses.
+func (cl *Client) Start(ctx context.Context) error {
+	resp, err := cl.pc.Start(ctx, &pbc.StartReq{})
+	if err != nil {
+		return err
+	}
+	if !resp.Starting {
+		return &RejectedError{Op: "start controller", Msg: resp.ErrorMsg}
+	}
+	return nil
+}
+
+// Stop asks the controller to stop. Job sets that are still running
+// are left as they are.
+func (cl *Client) Stop(ctx context.Context) error {
+	_, err := cl.pc.Stop(ctx, &pbc.StopReq{})
+	return err
+}
+
+// GetAllAgents gets the configurations of all registered agents.
+func (cl *Client) GetAllAgents(ctx context.Context) ([]*pbc.AgentConfig, error) {
+	resp, err := cl.pc.GetAllAgents(ctx, &pbc.GetAllAgentsReq{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Cfgs, nil
+}
+
+// GetAgent gets the configuration of the named agent. It returns a
+// *NotFoundError if there is no such agent.
+func (cl *Client) GetAgent(ctx context.Context, name string) (*pbc.AgentConfig, error) {
+	resp, err := cl.pc.GetAgent(ctx, &pbc.GetAgentReq{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, &NotFoundError{Kind: "agent", Name: name, Msg: resp.ErrorMsg}
+	}
+	return resp.Cfg, nil
+}
+
+// AddAgent registers an agent. It returns a *RejectedError if the
+// controller refuses.
+func (cl *Client) AddAgent(ctx context.Context, cfg *pbc.AgentConfig) error {
+	resp, err := cl.pc.AddAgent(ctx, &pbc.AddAgentReq{Cfg: cfg})
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return &RejectedError{Op: "register agent " + cfg.Name, Msg: resp.ErrorMsg}
+	}
+	return nil
+}
+
+// GetAllJobSetTemplates gets all registered job set templates.
+func (cl *Client) GetAllJobSetTemplates(ctx context.Context) ([]*pbc.JobSetTemplate, error) {
+	resp, err := cl.pc.GetAllJobSetTemplates(ctx, &pbc.GetAllJobSetTemplatesReq{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Jsts, nil
+}
+
+// AddJobSetTemplate registers a job set template. It returns a
+// *RejectedError if the controller refuses.
+func (cl *Client) AddJobSetTemplate(ctx context.Context, jst *pbc.JobSetTemplate) error {
+	resp, err := cl.pc.AddJobSetTemplate(ctx, &pbc.AddJobSetTemplateReq{Jst: jst})
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return &RejectedError{Op: "register job set template " + jst.Name, Msg: resp.ErrorMsg}
+	}
+	return nil
+}
+
+// GetAllJobSets gets the details of all job sets.
+func (cl *Client) GetAllJobSets(ctx context.Context) ([]*pbc.JobSetDetails, error) {
+	resp, err := cl.pc.GetAllJobSets(ctx, &pbc.GetAllJobSetsReq{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.JobSets, nil
+}
+
+// GetJobSet gets the details of the job set with the given ID. It
+// returns a *NotFoundError if there is no such job set.
+func (cl *Client) GetJobSet(ctx context.Context, id uint64) (*pbc.JobSetDetails, error) {
+	resp, err := cl.pc.GetJobSet(ctx, &pbc.GetJobSetReq{JobSetID: id})
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, &NotFoundError{Kind: "job set", Name: uintString(id), Msg: resp.ErrorMsg}
+	}
+	return resp.JobSet, nil
+}
+
+// StartJobSet starts a new job set from the named template, with the
+// given configuration values. It returns the new job set's ID, or a
+// *RejectedError if the controller refuses.
+func (cl *Client) StartJobSet(ctx context.Context, templateName string, cfgs map[string]string) (uint64, error) {
+	kvs := []*pbc.JobSetConfig{}
+	for k, v := range cfgs {
+		kvs = append(kvs, &pbc.JobSetConfig{Key: k, Value: v})
+	}
+
+	resp, err := cl.pc.StartJobSet(ctx, &pbc.StartJobSetReq{JstName: templateName, Cfgs: kvs})
+	if err != nil {
+		return 0, err
+	}
+	if !resp.Success {
+		return 0, &RejectedError{Op: "start job set for template " + templateName, Msg: resp.ErrorMsg}
+	}
+	return resp.JobSetID, nil
+}
@@ -0,0 +1,386 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package client_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	pbc "github.com/swinslow/peridot-core/pkg/controller"
+	pbs "github.com/swinslow/peridot-core/pkg/status"
+	"github.com/swinslow/peridotctl/pkg/client"
+	"github.com/swinslow/peridotctl/pkg/fakecontroller"
+)
+
+// newTestClient serves a fake controller with opts over an in-memory
+// connection, registers an agent "idsearcher" and a template "scan"
+// that runs it, and returns the fake controller and a Client connected
+// to it.
+func newTestClient(t *testing.T, opts fakecontroller.Options) (*fakecontroller.Server, *client.Client) {
+	t.Helper()
+	srv := fakecontroller.New(opts)
+	cl, err := srv.NewBufconnClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cl.Close()
+		srv.Shutdown()
+	})
+
+	ctx := testContext(t)
+	if err := cl.AddAgent(ctx, &pbc.AgentConfig{Name: "idsearcher", Url: "localhost", Port: 9001, Type: "idsearcher"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.AddJobSetTemplate(ctx, &pbc.JobSetTemplate{
+		Name:  "scan",
+		Steps: []*pbc.StepTemplate{{S: &pbc.StepTemplate_Agent{Agent: &pbc.StepAgentTemplate{Name: "idsearcher"}}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return srv, cl
+}
+
+// testContext returns a context that times out well before the test
+// does, so that a hung wait fails with a useful message.
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestClientStartStop(t *testing.T) {
+	_, cl := newTestClient(t, fakecontroller.Options{})
+	ctx := testContext(t)
+
+	if err := cl.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	err := cl.Start(ctx)
+	var rerr *client.RejectedError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("got error %v from second Start, want *RejectedError", err)
+	}
+	if rerr.Op != "start controller" || rerr.Msg != "controller is already running" {
+		t.Errorf("got %+v, want start controller rejected as already running", rerr)
+	}
+
+	if err := cl.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	st, err := cl.GetStatus(ctx)
+	if err != nil {
+		t.Fatalf("GetStatus: %v", err)
+	}
+	if st.RunStatus != pbs.Status_STOPPED {
+		t.Errorf("got status %s after Stop, want STOPPED", st.RunStatus)
+	}
+}
+
+func TestClientRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		started bool
+		call    func(ctx context.Context, cl *client.Client) error
+		wantOp  string
+		wantMsg string
+	}{
+		{"start job set when not running", false, func(ctx context.Context, cl *client.Client) error {
+			_, err := cl.StartJobSet(ctx, "scan", nil)
+			return err
+		}, "start job set for template scan", "controller is not running"},
+		{"start job set for unknown template", true, func(ctx context.Context, cl *client.Client) error {
+			_, err := cl.StartJobSet(ctx, "nosuchtemplate", map[string]string{"a": "1"})
+			return err
+		}, "start job set for template nosuchtemplate", "no job set template with name nosuchtemplate"},
+		{"add duplicate agent", true, func(ctx context.Context, cl *client.Client) error {
+			return cl.AddAgent(ctx, &pbc.AgentConfig{Name: "idsearcher"})
+		}, "register agent idsearcher", "agent with name idsearcher is already registered"},
+		{"add template with unknown agent", true, func(ctx context.Context, cl *client.Client) error {
+			return cl.AddJobSetTemplate(ctx, &pbc.JobSetTemplate{
+				Name:  "tag",
+				Steps: []*pbc.StepTemplate{{S: &pbc.StepTemplate_Agent{Agent: &pbc.StepAgentTemplate{Name: "tagger"}}}},
+			})
+		}, "register job set template tag", "no agent with name tagger"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, cl := newTestClient(t, fakecontroller.Options{Started: tc.started})
+
+			err := tc.call(testContext(t), cl)
+			var rerr *client.RejectedError
+			if !errors.As(err, &rerr) {
+				t.Fatalf("got error %v, want *RejectedError", err)
+			}
+			if rerr.Op != tc.wantOp || rerr.Msg != tc.wantMsg {
+				t.Errorf("got op %q and message %q, want %q and %q", rerr.Op, rerr.Msg, tc.wantOp, tc.wantMsg)
+			}
+			if want := "could not " + tc.wantOp + ": " + tc.wantMsg; err.Error() != want {
+				t.Errorf("got error %q, want %q", err, want)
+			}
+		})
+	}
+}
+
+func TestClientNotFound(t *testing.T) {
+	tests := []struct {
+		name     string
+		call     func(ctx context.Context, cl *client.Client) error
+		wantKind string
+		wantName string
+		wantMsg  string
+	}{
+		{"agent", func(ctx context.Context, cl *client.Client) error {
+			_, err := cl.GetAgent(ctx, "nosuchagent")
+			return err
+		}, "agent", "nosuchagent", "no agent with name nosuchagent"},
+		{"job set", func(ctx context.Context, cl *client.Client) error {
+			_, err := cl.GetJobSet(ctx, 99)
+			return err
+		}, "job set", "99", "no job set with ID 99"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, cl := newTestClient(t, fakecontroller.Options{Started: true})
+
+			err := tc.call(testContext(t), cl)
+			var nerr *client.NotFoundError
+			if !errors.As(err, &nerr) {
+				t.Fatalf("got error %v, want *NotFoundError", err)
+			}
+			if nerr.Kind != tc.wantKind || nerr.Name != tc.wantName || nerr.Msg != tc.wantMsg {
+				t.Errorf("got %+v, want %s %s: %s", nerr, tc.wantKind, tc.wantName, tc.wantMsg)
+			}
+		})
+	}
+}
+
+func TestClientGetAgent(t *testing.T) {
+	_, cl := newTestClient(t, fakecontroller.Options{})
+	ctx := testContext(t)
+
+	ac, err := cl.GetAgent(ctx, "idsearcher")
+	if err != nil {
+		t.Fatalf("GetAgent: %v", err)
+	}
+	if ac.Name != "idsearcher" || ac.Url != "localhost" || ac.Port != 9001 || ac.Type != "idsearcher" {
+		t.Errorf("got agent %+v, want the one registered", ac)
+	}
+
+	agents, err := cl.GetAllAgents(ctx)
+	if err != nil {
+		t.Fatalf("GetAllAgents: %v", err)
+	}
+	if len(agents) != 1 || agents[0].Name != "idsearcher" {
+		t.Errorf("got agents %v, want just idsearcher", agents)
+	}
+}
+
+func TestClientAdvance(t *testing.T) {
+	srv, cl := newTestClient(t, fakecontroller.Options{Started: true})
+	ctx := testContext(t)
+
+	id, err := cl.StartJobSet(ctx, "scan", map[string]string{"release": "1.0"})
+	if err != nil {
+		t.Fatalf("StartJobSet: %v", err)
+	}
+
+	wantStatus := []pbs.Status{pbs.Status_STARTUP, pbs.Status_RUNNING, pbs.Status_RUNNING, pbs.Status_RUNNING, pbs.Status_STOPPED}
+	for i, want := range wantStatus {
+		if i > 0 {
+			srv.Advance()
+		}
+		jsd, err := cl.GetJobSet(ctx, id)
+		if err != nil {
+			t.Fatalf("GetJobSet: %v", err)
+		}
+		if jsd.St.RunStatus != want {
+			t.Errorf("after %d steps: got %s, want %s", i, jsd.St.RunStatus, want)
+		}
+	}
+
+	jobSets, err := cl.GetAllJobSets(ctx)
+	if err != nil {
+		t.Fatalf("GetAllJobSets: %v", err)
+	}
+	if len(jobSets) != 1 || jobSets[0].JobSetID != id || jobSets[0].St.HealthStatus != pbs.Health_OK {
+		t.Errorf("got job sets %v, want job set %d stopped with health OK", jobSets, id)
+	}
+	if cfgs := jobSets[0].Cfgs; len(cfgs) != 1 || cfgs[0].Key != "release" || cfgs[0].Value != "1.0" {
+		t.Errorf("got configs %v, want release:1.0", cfgs)
+	}
+}
+
+func TestClientWaitJobSet(t *testing.T) {
+	_, cl := newTestClient(t, fakecontroller.Options{Started: true, StepInterval: time.Millisecond})
+	ctx := testContext(t)
+
+	id, err := cl.StartJobSet(ctx, "scan", nil)
+	if err != nil {
+		t.Fatalf("StartJobSet: %v", err)
+	}
+	jsd, err := cl.WaitJobSet(ctx, id, time.Millisecond)
+	if err != nil {
+		t.Fatalf("WaitJobSet: %v", err)
+	}
+	if jsd.St.RunStatus != pbs.Status_STOPPED {
+		t.Errorf("got %s, want STOPPED", jsd.St.RunStatus)
+	}
+
+	_, err = cl.WaitJobSet(ctx, 99, time.Millisecond)
+	var nerr *client.NotFoundError
+	if !errors.As(err, &nerr) {
+		t.Errorf("got error %v waiting for a missing job set, want *NotFoundError", err)
+	}
+}
+
+func TestClientWaitJobSetsIdle(t *testing.T) {
+	srv, cl := newTestClient(t, fakecontroller.Options{Started: true})
+	ctx := testContext(t)
+
+	for i := 0; i < 2; i++ {
+		if _, err := cl.StartJobSet(ctx, "scan", nil); err != nil {
+			t.Fatalf("StartJobSet: %v", err)
+		}
+	}
+
+	// nothing moves while the controller is stopped, so waiting times
+	// out
+	srv.Advance()
+	if err := cl.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	counts := []int{}
+	shortCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	err := cl.WaitJobSetsIdle(shortCtx, time.Millisecond, func(running int) {
+		counts = append(counts, running)
+	})
+	// the deadline can pass during a call, giving a gRPC error, or
+	// between calls, giving the context's error
+	if err == nil {
+		t.Errorf("got no error, want the wait to time out")
+	}
+	if len(counts) != 1 || counts[0] != 2 {
+		t.Errorf("got running counts %v, want just 2", counts)
+	}
+
+	// once started again, the job sets finish
+	if err := cl.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				srv.Advance()
+			}
+		}
+	}()
+	if err := cl.WaitJobSetsIdle(ctx, time.Millisecond, nil); err != nil {
+		t.Errorf("WaitJobSetsIdle: %v", err)
+	}
+}
+
+func TestClientWaitRunning(t *testing.T) {
+	_, cl := newTestClient(t, fakecontroller.Options{})
+	ctx := testContext(t)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cl.Start(ctx)
+	}()
+
+	st, err := cl.WaitRunning(ctx, time.Millisecond)
+	if err != nil {
+		t.Fatalf("WaitRunning: %v", err)
+	}
+	if st.RunStatus != pbs.Status_RUNNING {
+		t.Errorf("got %s, want RUNNING", st.RunStatus)
+	}
+}
+
+func TestClientWaitStatus(t *testing.T) {
+	_, cl := newTestClient(t, fakecontroller.Options{})
+	ctx := testContext(t)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cl.Start(ctx)
+	}()
+
+	seen := []pbs.Status{}
+	st, err := cl.WaitStatus(ctx, pbs.Status_RUNNING, time.Millisecond, func(s pbs.Status) {
+		seen = append(seen, s)
+	})
+	if err != nil {
+		t.Fatalf("WaitStatus: %v", err)
+	}
+	if st.RunStatus != pbs.Status_RUNNING {
+		t.Errorf("got %s, want RUNNING", st.RunStatus)
+	}
+	if len(seen) != 2 || seen[0] != pbs.Status_STARTUP || seen[1] != pbs.Status_RUNNING {
+		t.Errorf("got statuses %v, want STARTUP then RUNNING", seen)
+	}
+}
+
+func TestClientCheckHealth(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := func() time.Time { return start }
+
+	tests := []struct {
+		name       string
+		started    bool
+		startScan  bool
+		opts       client.HealthOptions
+		wantState  client.HealthState
+		wantChecks []string
+	}{
+		{"not started", false, false, client.HealthOptions{}, client.HealthCritical,
+			[]string{"controller is STARTUP"}},
+		{"running", true, false, client.HealthOptions{}, client.HealthOK,
+			[]string{"controller is RUNNING with health OK"}},
+		{"new job set", true, true, client.HealthOptions{MaxJobSetAge: time.Hour, Now: now}, client.HealthOK,
+			[]string{"controller is RUNNING with health OK", "1 job set running, none longer than 1h0m0s"}},
+		{"old job set", true, true, client.HealthOptions{MaxJobSetAge: time.Second, Now: func() time.Time { return start.Add(time.Minute) }}, client.HealthWarning,
+			[]string{"controller is RUNNING with health OK", "1 job set running longer than 1s: 1 (since 2020-01-01T00:00:00Z)"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, cl := newTestClient(t, fakecontroller.Options{Started: tc.started, Now: now})
+			ctx := testContext(t)
+			if tc.startScan {
+				if _, err := cl.StartJobSet(ctx, "scan", nil); err != nil {
+					t.Fatalf("StartJobSet: %v", err)
+				}
+				srv.Advance()
+			}
+
+			report := cl.CheckHealth(ctx, tc.opts)
+			if report.State != tc.wantState {
+				t.Errorf("got state %s, want %s", report.State, tc.wantState)
+			}
+			msgs := []string{}
+			for _, c := range report.Checks {
+				msgs = append(msgs, c.Msg)
+			}
+			if strings.Join(msgs, "\n") != strings.Join(tc.wantChecks, "\n") {
+				t.Errorf("got checks %q, want %q", msgs, tc.wantChecks)
+			}
+		})
+	}
+}
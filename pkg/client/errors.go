@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package client
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// RejectedError is returned when the controller receives a request but
+// reports that it was unsuccessful.
+type RejectedError struct {
+	// Op describes the request, such as "register agent foo".
+	Op string
+	// Msg is the error message from the controller.
+	Msg string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("could not %s: %s", e.Op, e.Msg)
+}
+
+// NotFoundError is returned when the controller reports that a
+// requested object does not exist.
+type NotFoundError struct {
+	// Kind is the kind of object, such as "agent" or "job set".
+	Kind string
+	// Name is the object's name or ID.
+	Name string
+	// Msg is the error message from the controller.
+	Msg string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s %s not found: %s", e.Kind, e.Name, e.Msg)
+}
+
+func uintString(n uint64) string {
+	return strconv.FormatUint(n, 10)
+}
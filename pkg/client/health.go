@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	pbs "github.com/swinslow/peridot-core/pkg/status"
+)
+
+// HealthState is the outcome of a health check. The values match the
+// exit codes used by Nagios-style monitoring plugins.
+type HealthState int
+
+// Health states, from best to worst.
+const (
+	HealthOK HealthState = iota
+	HealthWarning
+	HealthCritical
+	HealthUnknown
+)
+
+func (s HealthState) String() string {
+	switch s {
+	case HealthOK:
+		return "OK"
+	case HealthWarning:
+		return "WARNING"
+	case HealthCritical:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// HealthCheck is the outcome of one health check.
+type HealthCheck struct {
+	// Name says what was checked, such as "controller" or "agents".
+	Name string
+	// State is the check's outcome.
+	State HealthState
+	// Msg describes the outcome.
+	Msg string
+}
+
+// HealthOptions says which health checks to make beyond checking the
+// controller itself.
+type HealthOptions struct {
+	// CheckAgents makes CheckHealth try to connect to every registered
+	// agent.
+	CheckAgents bool
+	// AgentTimeout is how long to wait when connecting to each agent.
+	// If zero, it is 5 seconds.
+	AgentTimeout time.Duration
+	// MaxJobSetAge is how long a job set can be running before it is
+	// reported. Zero means job sets are not checked.
+	MaxJobSetAge time.Duration
+	// Now returns the current time, for checking job set ages. If nil,
+	// time.Now is used.
+	Now func() time.Time
+}
+
+// HealthReport is the outcome of all health checks.
+type HealthReport struct {
+	// State is the worst state of any check.
+	State HealthState
+	// Checks are the checks that were made, in order.
+	Checks []HealthCheck
+}
+
+func (r *HealthReport) add(name string, state HealthState, format string, v ...interface{}) {
+	r.Checks = append(r.Checks, HealthCheck{Name: name, State: state, Msg: fmt.Sprintf(format, v...)})
+	if state > r.State {
+		r.State = state
+	}
+}
+
+// CheckHealth checks that the controller is reachable, running and
+// healthy, and then makes any other checks asked for in opts. Problems
+// are reported in the returned report rather than as errors. If the
+// controller can't be reached, the other checks are skipped.
+func (cl *Client) CheckHealth(ctx context.Context, opts HealthOptions) *HealthReport {
+	report := &HealthReport{}
+
+	st, err := cl.GetStatus(ctx)
+	if err != nil {
+		report.add("controller", HealthCritical, "could not get status: %v", err)
+		return report
+	}
+	switch {
+	case st.RunStatus != pbs.Status_RUNNING:
+		report.add("controller", HealthCritical, "controller is %s", st.RunStatus.String())
+	case st.HealthStatus == pbs.Health_OK:
+		report.add("controller", HealthOK, "controller is RUNNING with health OK")
+	case st.HealthStatus == pbs.Health_DEGRADED:
+		report.add("controller", HealthWarning, "controller health is DEGRADED: %s", st.ErrorMsg)
+	default:
+		report.add("controller", HealthCritical, "controller health is %s: %s", st.HealthStatus.String(), st.ErrorMsg)
+	}
+
+	if opts.CheckAgents {
+		cl.checkAgents(ctx, opts, report)
+	}
+	if opts.MaxJobSetAge > 0 {
+		cl.checkJobSetAges(ctx, opts, report)
+	}
+
+	return report
+}
+
+// checkAgents tries to connect to each registered agent.
+func (cl *Client) checkAgents(ctx context.Context, opts HealthOptions, report *HealthReport) {
+	agents, err := cl.GetAllAgents(ctx)
+	if err != nil {
+		report.add("agents", HealthUnknown, "could not get agents: %v", err)
+		return
+	}
+
+	agentTimeout := opts.AgentTimeout
+	if agentTimeout <= 0 {
+		agentTimeout = 5 * time.Second
+	}
+
+	unreachable := []string{}
+	for _, ac := range agents {
+		addr := net.JoinHostPort(ac.Url, strconv.FormatUint(uint64(ac.Port), 10))
+		dialer := net.Dialer{Timeout: agentTimeout}
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			unreachable = append(unreachable, fmt.Sprintf("%s at %s", ac.Name, addr))
+			continue
+		}
+		conn.Close()
+	}
+
+	if len(unreachable) > 0 {
+		report.add("agents", HealthCritical, "%d of %s unreachable: %s", len(unreachable), plural(len(agents), "agent"), strings.Join(unreachable, ", "))
+		return
+	}
+	report.add("agents", HealthOK, "all %s reachable", plural(len(agents), "agent"))
+}
+
+// checkJobSetAges looks for job sets that have been running longer
+// than opts.MaxJobSetAge.
+func (cl *Client) checkJobSetAges(ctx context.Context, opts HealthOptions, report *HealthReport) {
+	jobSets, err := cl.GetAllJobSets(ctx)
+	if err != nil {
+		report.add("jobsets", HealthUnknown, "could not get job sets: %v", err)
+		return
+	}
+
+	now := time.Now
+	if opts.Now != nil {
+		now = opts.Now
+	}
+	cutoff := now().Add(-opts.MaxJobSetAge)
+
+	running := 0
+	old := []string{}
+	for _, jsd := range jobSets {
+		if jsd.St.RunStatus != pbs.Status_RUNNING {
+			continue
+		}
+		running++
+		started := time.Unix(jsd.St.TimeStarted, 0)
+		if jsd.St.TimeStarted != 0 && started.Before(cutoff) {
+			old = append(old, fmt.Sprintf("%d (since %s)", jsd.JobSetID, started.UTC().Format(time.RFC3339)))
+		}
+	}
+
+	if len(old) > 0 {
+		report.add("jobsets", HealthWarning, "%s running longer than %v: %s", plural(len(old), "job set"), opts.MaxJobSetAge, strings.Join(old, ", "))
+		return
+	}
+	report.add("jobsets", HealthOK, "%s running, none longer than %v", plural(running, "job set"), opts.MaxJobSetAge)
+}
+
+// plural returns n followed by noun, adding an "s" unless n is 1.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package client
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Levels of detail for LoggingInterceptor. Each level also logs
+// everything that the levels below it log.
+const (
+	// LogCalls logs each call's method, status code and latency.
+	LogCalls = 1
+	// LogMetadata also logs the metadata sent and received.
+	LogMetadata = 2
+	// LogPayloads also logs the request and response messages, as
+	// protobuf JSON.
+	LogPayloads = 3
+)
+
+// LoggingInterceptor returns a gRPC client interceptor that logs each
+// call with logf, in as much detail as level asks for.
+func LoggingInterceptor(level int, logf func(format string, v ...interface{})) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if level < LogCalls {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		if level >= LogMetadata {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			logf("%s request metadata: %s", method, formatMetadata(md))
+			if deadline, ok := ctx.Deadline(); ok {
+				logf("%s deadline: %v", method, time.Until(deadline).Round(time.Millisecond))
+			}
+		}
+		if level >= LogPayloads {
+			logf("%s request: %s", method, formatPayload(req))
+		}
+
+		var header, trailer metadata.MD
+		opts = append(opts, grpc.Header(&header), grpc.Trailer(&trailer))
+
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		latency := time.Since(start)
+
+		st := status.Convert(err)
+		if err != nil {
+			logf("%s %s in %v: %s", method, st.Code(), latency.Round(time.Microsecond), st.Message())
+		} else {
+			logf("%s %s in %v", method, st.Code(), latency.Round(time.Microsecond))
+		}
+
+		if level >= LogMetadata {
+			logf("%s response headers: %s", method, formatMetadata(header))
+			logf("%s response trailers: %s", method, formatMetadata(trailer))
+		}
+		if level >= LogPayloads && err == nil {
+			logf("%s response: %s", method, formatPayload(reply))
+		}
+
+		return err
+	}
+}
+
+func formatMetadata(md metadata.MD) string {
+	if len(md) == 0 {
+		return "(none)"
+	}
+
+	keys := []string{}
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := []string{}
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+strings.Join(md[k], ","))
+	}
+	return strings.Join(pairs, " ")
+}
+
+func formatPayload(m interface{}) string {
+	data, err := marshalMessage(m)
+	if err != nil {
+		return "(" + err.Error() + ")"
+	}
+	return string(data)
+}
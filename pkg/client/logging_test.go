@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	pbc "github.com/swinslow/peridot-core/pkg/controller"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestLoggingInterceptor(t *testing.T) {
+	const method = "/controller.Controller/GetStatus"
+
+	tests := []struct {
+		name    string
+		level   int
+		callErr error
+		// want is the start of each line that should be logged, after
+		// the method name
+		want []string
+	}{
+		{"off", 0, nil, []string{}},
+		{"calls", LogCalls, nil, []string{"OK in "}},
+		{"calls with error", LogCalls, status.Error(codes.Unavailable, "down"), []string{"Unavailable in "}},
+		{"metadata", LogMetadata, nil, []string{
+			"request metadata: client=test",
+			"OK in ",
+			"response headers: (none)",
+			"response trailers: (none)",
+		}},
+		{"payloads", LogPayloads, nil, []string{
+			"request metadata: client=test",
+			"request: {}",
+			"OK in ",
+			"response headers: (none)",
+			"response trailers: (none)",
+			"response: {",
+		}},
+		{"payloads with error", LogPayloads, status.Error(codes.Unavailable, "down"), []string{
+			"request metadata: client=test",
+			"request: {}",
+			"Unavailable in ",
+			"response headers: (none)",
+			"response trailers: (none)",
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			lines := []string{}
+			logf := func(format string, v ...interface{}) {
+				lines = append(lines, fmt.Sprintf(format, v...))
+			}
+			invoke := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				return tc.callErr
+			}
+
+			ctx := metadata.AppendToOutgoingContext(context.Background(), "client", "test")
+			err := LoggingInterceptor(tc.level, logf)(ctx, method, &pbc.GetStatusReq{}, &pbc.GetStatusResp{}, nil, invoke)
+			if err != tc.callErr {
+				t.Errorf("got error %v, want the call's own error %v", err, tc.callErr)
+			}
+
+			if len(lines) != len(tc.want) {
+				t.Fatalf("got lines:\n%s\nwant %d lines", strings.Join(lines, "\n"), len(tc.want))
+			}
+			for i, line := range lines {
+				if want := method + " " + tc.want[i]; !strings.HasPrefix(line, want) {
+					t.Errorf("line %d: got %q, want it to start with %q", i, line, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatMetadata(t *testing.T) {
+	md := metadata.Pairs("b", "2", "a", "1", "b", "3")
+	if got, want := formatMetadata(md), "a=1 b=2,3"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := formatMetadata(nil); got != "(none)" {
+		t.Errorf("got %q for no metadata, want (none)", got)
+	}
+}
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package client
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetryPolicy says how to retry calls that fail because the controller
+// is unavailable. Only calls that just read from the controller, whose
+// method names start with "Get", are retried, since calls that change
+// something might have taken effect before failing.
+type RetryPolicy struct {
+	// Retries is how many times to retry a call after it first fails.
+	// Zero means calls are not retried.
+	Retries int
+	// InitialBackoff is how long to wait before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff is the longest to wait between retries.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is the RetryPolicy used by peridotctl unless it is
+// told otherwise.
+var DefaultRetryPolicy = RetryPolicy{
+	Retries:        3,
+	InitialBackoff: 250 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+}
+
+// backoff returns how long to wait before the given retry, counting
+// from 1, doubling each time up to MaxBackoff.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < retry; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return d
+}
+
+// RetryInterceptor returns a gRPC client interceptor that retries read
+// calls following p. It gives up early if the call's context is done
+// while waiting to retry.
+func RetryInterceptor(p RetryPolicy) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if !isReadMethod(method) {
+			return err
+		}
+
+		for retry := 1; retry <= p.Retries && isUnavailable(err); retry++ {
+			timer := time.NewTimer(p.backoff(retry))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+
+			err = invoker(ctx, method, req, reply, cc, opts...)
+		}
+		return err
+	}
+}
+
+// isReadMethod returns true if the full gRPC method name is for a call
+// that only reads from the controller.
+func isReadMethod(method string) bool {
+	return strings.HasPrefix(method[strings.LastIndex(method, "/")+1:], "Get")
+}
+
+// isUnavailable returns true if err means the controller could not be
+// reached, so that trying again later might work.
+func isUnavailable(err error) bool {
+	return err != nil && status.Code(err) == codes.Unavailable
+}
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{Retries: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second}
+	for i, w := range want {
+		if got := p.backoff(i + 1); got != w {
+			t.Errorf("retry %d: got %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestRetryInterceptor(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "down")
+	notFound := status.Error(codes.NotFound, "missing")
+
+	tests := []struct {
+		name      string
+		method    string
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{"read succeeds", "/controller.Controller/GetStatus", []error{nil}, 1, nil},
+		{"read retried until it succeeds", "/controller.Controller/GetStatus", []error{unavailable, unavailable, nil}, 3, nil},
+		{"read gives up after retries", "/controller.Controller/GetStatus", []error{unavailable, unavailable, unavailable, unavailable}, 3, unavailable},
+		{"other errors not retried", "/controller.Controller/GetAgent", []error{notFound, nil}, 1, notFound},
+		{"writes not retried", "/controller.Controller/Start", []error{unavailable, nil}, 1, unavailable},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			invoke := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				err := tc.errs[calls]
+				calls++
+				return err
+			}
+
+			p := RetryPolicy{Retries: 2, InitialBackoff: time.Millisecond}
+			err := RetryInterceptor(p)(context.Background(), tc.method, nil, nil, nil, invoke)
+			if err != tc.wantErr {
+				t.Errorf("got error %v, want %v", err, tc.wantErr)
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("got %d calls, want %d", calls, tc.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryInterceptorContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	invoke := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		cancel()
+		return status.Error(codes.Unavailable, "down")
+	}
+
+	p := RetryPolicy{Retries: 3, InitialBackoff: time.Hour}
+	if err := RetryInterceptor(p)(ctx, "/controller.Controller/GetStatus", nil, nil, nil, invoke); status.Code(err) != codes.Unavailable {
+		t.Errorf("got error %v, want Unavailable", err)
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1 once the context is done", calls)
+	}
+}
@@ -0,0 +1,192 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Session is a recording of the calls made to a controller, which can
+// be replayed later without the controller.
+type Session struct {
+	// Command is the command that made the calls, if known.
+	Command string `json:"command,omitempty"`
+	// Calls are the calls in the order they were made.
+	Calls []*RecordedCall `json:"calls"`
+}
+
+// RecordedCall is one call in a Session.
+type RecordedCall struct {
+	// Method is the full gRPC method name, such as
+	// "/controller.Controller/GetStatus".
+	Method string `json:"method"`
+	// Request is the request message, as protobuf JSON.
+	Request json.RawMessage `json:"request"`
+	// Response is the response message, as protobuf JSON, if the call
+	// succeeded.
+	Response json.RawMessage `json:"response,omitempty"`
+	// Error is the error, if the call failed.
+	Error *RecordedError `json:"error,omitempty"`
+}
+
+// RecordedError is the gRPC status of a failed call.
+type RecordedError struct {
+	Code    codes.Code `json:"code"`
+	Message string     `json:"message"`
+}
+
+// LoadSession reads a Session from a file written by Recorder.
+func LoadSession(filePath string) (*Session, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	sess := &Session{}
+	if err := json.Unmarshal(data, sess); err != nil {
+		return nil, fmt.Errorf("error parsing session %s: %v", filePath, err)
+	}
+	return sess, nil
+}
+
+// Recorder records the calls made through its Interceptor.
+type Recorder struct {
+	mu   sync.Mutex
+	sess Session
+	// err is the first error recording a call, returned by WriteFile.
+	err error
+}
+
+// NewRecorder creates a Recorder with no calls. The command is saved in
+// the session, to show what made the calls.
+func NewRecorder(command string) *Recorder {
+	return &Recorder{sess: Session{Command: command, Calls: []*RecordedCall{}}}
+}
+
+// Interceptor returns a gRPC client interceptor that makes each call as
+// usual, and records its request and response or error.
+func (r *Recorder) Interceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		callErr := invoker(ctx, method, req, reply, cc, opts...)
+
+		call := &RecordedCall{Method: method}
+		var err error
+		call.Request, err = marshalMessage(req)
+		if err != nil {
+			err = fmt.Errorf("could not record request for %s: %v", method, err)
+		} else if callErr != nil {
+			st := status.Convert(callErr)
+			call.Error = &RecordedError{Code: st.Code(), Message: st.Message()}
+		} else if call.Response, err = marshalMessage(reply); err != nil {
+			err = fmt.Errorf("could not record response for %s: %v", method, err)
+		}
+
+		r.mu.Lock()
+		if err == nil {
+			r.sess.Calls = append(r.sess.Calls, call)
+		} else if r.err == nil {
+			r.err = err
+		}
+		r.mu.Unlock()
+		return callErr
+	}
+}
+
+// WriteFile writes the calls recorded so far to a file, which
+// LoadSession can read. If a call could not be recorded, the file is
+// not written and the error is returned, since the recording would be
+// incomplete.
+func (r *Recorder) WriteFile(filePath string) error {
+	r.mu.Lock()
+	recordErr := r.err
+	data, err := json.MarshalIndent(&r.sess, "", "  ")
+	r.mu.Unlock()
+	if recordErr != nil {
+		return recordErr
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filePath, append(data, '\n'), 0644)
+}
+
+// NewReplay creates a Client that answers calls from sess instead of
+// connecting to a controller. Each call gets the response of the first
+// call in sess with the same method that hasn't been used yet, or an
+// error if there isn't one. opts.Address is ignored, and interceptors
+// added to opts.DialOptions with grpc.WithChainUnaryInterceptor see
+// each call before it is answered.
+func NewReplay(sess *Session, opts Options) (*Client, error) {
+	rp := &replayer{sess: sess, used: make([]bool, len(sess.Calls))}
+
+	// calls never get as far as the connection, so make sure that it
+	// never reaches the network either
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		return nil, errors.New("replaying a recorded session")
+	}
+	opts.Address = "replay"
+	// the connection is never ready, so don't wait for it
+	opts.DialTimeout = 0
+	opts.DialOptions = append(opts.DialOptions,
+		grpc.WithContextDialer(dialer),
+		grpc.WithChainUnaryInterceptor(rp.intercept),
+	)
+	return New(opts)
+}
+
+type replayer struct {
+	mu   sync.Mutex
+	sess *Session
+	used []bool
+}
+
+func (rp *replayer) intercept(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	for i, call := range rp.sess.Calls {
+		if rp.used[i] || call.Method != method {
+			continue
+		}
+		rp.used[i] = true
+
+		if call.Error != nil {
+			return status.Error(call.Error.Code, call.Error.Message)
+		}
+		return unmarshalMessage(call.Response, reply)
+	}
+
+	return status.Errorf(codes.FailedPrecondition, "no recorded response left for %s", method)
+}
+
+func marshalMessage(m interface{}) (json.RawMessage, error) {
+	pm, ok := m.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a protobuf message", m)
+	}
+	s, err := (&jsonpb.Marshaler{}).MarshalToString(pm)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(s), nil
+}
+
+func unmarshalMessage(data json.RawMessage, m interface{}) error {
+	pm, ok := m.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not a protobuf message", m)
+	}
+	return jsonpb.UnmarshalString(string(data), pm)
+}
@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package client
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pbc "github.com/swinslow/peridot-core/pkg/controller"
+	"google.golang.org/grpc"
+)
+
+func TestRecorderRecordsCalls(t *testing.T) {
+	r := NewRecorder("peridotctl controller status")
+	invoke := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	}
+	if err := r.Interceptor()(context.Background(), "/controller.Controller/GetStatus", &pbc.GetStatusReq{}, &pbc.GetStatusResp{}, nil, invoke); err != nil {
+		t.Fatalf("interceptor: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "session.json")
+	if err := r.WriteFile(path); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	sess, err := LoadSession(path)
+	if err != nil {
+		t.Fatalf("LoadSession: %v", err)
+	}
+	if sess.Command != "peridotctl controller status" || len(sess.Calls) != 1 || sess.Calls[0].Method != "/controller.Controller/GetStatus" {
+		t.Errorf("got session %+v", sess)
+	}
+}
+
+func TestRecorderReportsMarshalErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		req   interface{}
+		reply interface{}
+		want  string
+	}{
+		{"request", "not a message", &pbc.GetStatusResp{}, "could not record request"},
+		{"response", &pbc.GetStatusReq{}, 42, "could not record response"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewRecorder("test")
+			invoke := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				return nil
+			}
+			if err := r.Interceptor()(context.Background(), "/controller.Controller/GetStatus", tc.req, tc.reply, nil, invoke); err != nil {
+				t.Fatalf("interceptor returned %v, want the call's own result", err)
+			}
+
+			err := r.WriteFile(filepath.Join(t.TempDir(), "session.json"))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("WriteFile returned %v, want error containing %q", err, tc.want)
+			}
+		})
+	}
+}
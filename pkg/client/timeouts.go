@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package client
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+// CallTimeoutInterceptor returns a gRPC client interceptor that gives
+// each call its own deadline of d, within whatever deadline its
+// context already has.
+func CallTimeoutInterceptor(d time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// DialTimeoutInterceptor returns a gRPC client interceptor that waits
+// up to d for the connection to the controller to be ready before
+// making a call, and fails the call if it isn't. Dialing doesn't wait
+// for the connection, so this is where an unreachable controller gets
+// noticed.
+func DialTimeoutInterceptor(d time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if err := waitReady(ctx, cc, d); err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func waitReady(ctx context.Context, cc *grpc.ClientConn, d time.Duration) error {
+	dialCtx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+
+	for {
+		state := cc.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		if state == connectivity.Idle {
+			cc.Connect()
+		}
+
+		if !cc.WaitForStateChange(dialCtx, state) {
+			// the call's own context ending is reported by the call
+			if ctx.Err() != nil {
+				return nil
+			}
+			return status.Errorf(codes.Unavailable, "could not connect to controller at %s within %v (connection is %s)", cc.Target(), d, state)
+		}
+	}
+}
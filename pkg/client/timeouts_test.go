@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCallTimeoutInterceptor(t *testing.T) {
+	invoke := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			t.Errorf("got no deadline for call")
+		} else if d := time.Until(deadline); d <= 0 || d > time.Minute {
+			t.Errorf("got deadline %v from now, want within a minute", d)
+		}
+		return nil
+	}
+
+	if err := CallTimeoutInterceptor(time.Minute)(context.Background(), "/controller.Controller/GetStatus", nil, nil, nil, invoke); err != nil {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestDialTimeoutInterceptor(t *testing.T) {
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		return nil, errors.New("unreachable")
+	}
+	cc, err := grpc.Dial("unreachable", grpc.WithInsecure(), grpc.WithContextDialer(dialer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	called := false
+	invoke := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		called = true
+		return nil
+	}
+
+	err = DialTimeoutInterceptor(50*time.Millisecond)(context.Background(), "/controller.Controller/GetStatus", nil, nil, cc, invoke)
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("got error %v, want Unavailable", err)
+	}
+	if called {
+		t.Errorf("call was made without a connection")
+	}
+}
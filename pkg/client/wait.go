@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package client
+
+import (
+	"context"
+	"time"
+
+	pbc "github.com/swinslow/peridot-core/pkg/controller"
+	pbs "github.com/swinslow/peridot-core/pkg/status"
+)
+
+// WaitJobSet polls the job set with the given ID every interval until
+// it has stopped, and then returns its details. It returns early with
+// ctx's error if ctx is done first.
+func (cl *Client) WaitJobSet(ctx context.Context, id uint64, interval time.Duration) (*pbc.JobSetDetails, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		jsd, err := cl.GetJobSet(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if jsd.St.RunStatus == pbs.Status_STOPPED {
+			return jsd, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// WaitRunning polls the controller's status every interval until it is
+// reachable and reports that it is running, and then returns its
+// status. It returns early with ctx's error if ctx is done first, or
+// with any error other than the controller being unavailable.
+func (cl *Client) WaitRunning(ctx context.Context, interval time.Duration) (*pbc.GetStatusResp, error) {
+	return cl.WaitStatus(ctx, pbs.Status_RUNNING, interval, nil)
+}
+
+// WaitStatus polls the controller's status every interval until it is
+// reachable and reports the wanted run status, and then returns its
+// status. If changed is not nil, it is called with each run status
+// seen that differs from the one before, starting with the first. It
+// returns early with ctx's error if ctx is done first, or with any
+// error other than the controller being unavailable.
+func (cl *Client) WaitStatus(ctx context.Context, want pbs.Status, interval time.Duration, changed func(pbs.Status)) (*pbc.GetStatusResp, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	seen := false
+	var last pbs.Status
+	for {
+		resp, err := cl.GetStatus(ctx)
+		if err != nil && !isUnavailable(err) {
+			return nil, err
+		}
+		if err == nil {
+			if changed != nil && (!seen || resp.RunStatus != last) {
+				changed(resp.RunStatus)
+			}
+			seen = true
+			last = resp.RunStatus
+			if resp.RunStatus == want {
+				return resp, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// WaitJobSetsIdle polls the controller's job sets every interval until
+// none of them are running. If waiting is not nil, it is called with
+// the number of running job sets whenever that number changes, other
+// than to zero. It returns early with ctx's error if ctx is done first.
+func (cl *Client) WaitJobSetsIdle(ctx context.Context, interval time.Duration, waiting func(running int)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := 0
+	for {
+		jobSets, err := cl.GetAllJobSets(ctx)
+		if err != nil {
+			return err
+		}
+		running := 0
+		for _, jsd := range jobSets {
+			if jsd.St.RunStatus != pbs.Status_STOPPED {
+				running++
+			}
+		}
+		if running == 0 {
+			return nil
+		}
+		if waiting != nil && running != last {
+			waiting(running)
+		}
+		last = running
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,298 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+// Package fakecontroller provides an in-memory stand-in for a peridot
+// controller, for tests and offline demos. It keeps agents, job set
+// templates and job sets in memory, and simulates job sets running by
+// moving their steps forward one at a time.
+//
+// Agent steps finish with health OK, unless the agent was registered
+// with a "fake-result" config value of "degraded" or "error", in which
+// case they finish with that health instead. A step that finishes with
+// health ERROR stops its job set.
+package fakecontroller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	pbc "github.com/swinslow/peridot-core/pkg/controller"
+	pbs "github.com/swinslow/peridot-core/pkg/status"
+)
+
+// Options contains the settings for a fake controller.
+type Options struct {
+	// StepInterval is how often running job sets move forward while
+	// serving. If it is zero, job sets only move forward when Advance
+	// is called.
+	StepInterval time.Duration
+	// Started is true if the controller should begin in the running
+	// state, rather than waiting for a Start call.
+	Started bool
+	// Now returns the current time, for job set start and finish times.
+	// If nil, time.Now is used.
+	Now func() time.Time
+	// Logf, if set, is called to report changes such as agents being
+	// registered and job sets starting and stopping.
+	Logf func(format string, v ...interface{})
+}
+
+// Server is an in-memory implementation of pbc.ControllerServer.
+type Server struct {
+	opts Options
+
+	mu        sync.Mutex
+	runStatus pbs.Status
+	health    pbs.Health
+	agents    map[string]*pbc.AgentConfig
+	templates map[string]*pbc.JobSetTemplate
+	jobSets   map[uint64]*pbc.JobSetDetails
+	nextJSID  uint64
+	nextJobID uint64
+	nextStep  uint64
+
+	// set while serving
+	stopTicker chan struct{}
+	grpcStop   func()
+}
+
+// New creates a fake controller with no agents, templates or job sets.
+func New(opts Options) *Server {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	s := &Server{
+		opts:      opts,
+		runStatus: pbs.Status_STARTUP,
+		health:    pbs.Health_OK,
+		agents:    map[string]*pbc.AgentConfig{},
+		templates: map[string]*pbc.JobSetTemplate{},
+		jobSets:   map[uint64]*pbc.JobSetDetails{},
+		nextJSID:  1,
+		nextJobID: 1,
+		nextStep:  1,
+	}
+	if opts.Started {
+		s.runStatus = pbs.Status_RUNNING
+	}
+	return s
+}
+
+func (s *Server) logf(format string, v ...interface{}) {
+	if s.opts.Logf != nil {
+		s.opts.Logf(format, v...)
+	}
+}
+
+// GetStatus reports the controller's run status and health.
+func (s *Server) GetStatus(ctx context.Context, req *pbc.GetStatusReq) (*pbc.GetStatusResp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return &pbc.GetStatusResp{
+		RunStatus:    s.runStatus,
+		HealthStatus: s.health,
+		OutputMsg:    fmt.Sprintf("fake controller: %d agents, %d job set templates, %d job sets", len(s.agents), len(s.templates), len(s.jobSets)),
+	}, nil
+}
+
+// Start moves the controller to the running state.
+func (s *Server) Start(ctx context.Context, req *pbc.StartReq) (*pbc.StartResp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.runStatus == pbs.Status_RUNNING {
+		return &pbc.StartResp{Starting: false, ErrorMsg: "controller is already running"}, nil
+	}
+
+	s.runStatus = pbs.Status_RUNNING
+	s.logf("controller started")
+	return &pbc.StartResp{Starting: true}, nil
+}
+
+// Stop moves the controller to the stopped state. Job sets that are
+// still running stay as they are, and no longer move forward.
+func (s *Server) Stop(ctx context.Context, req *pbc.StopReq) (*pbc.StopResp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.runStatus = pbs.Status_STOPPED
+	s.logf("controller stopped")
+	return &pbc.StopResp{}, nil
+}
+
+// GetAgent gets a registered agent's configuration.
+func (s *Server) GetAgent(ctx context.Context, req *pbc.GetAgentReq) (*pbc.GetAgentResp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ac, ok := s.agents[req.Name]
+	if !ok {
+		return &pbc.GetAgentResp{Success: false, ErrorMsg: fmt.Sprintf("no agent with name %s", req.Name)}, nil
+	}
+	return &pbc.GetAgentResp{Success: true, Cfg: cloneAgentConfig(ac)}, nil
+}
+
+// GetAllAgents gets the configurations of all registered agents, sorted
+// by name.
+func (s *Server) GetAllAgents(ctx context.Context, req *pbc.GetAllAgentsReq) (*pbc.GetAllAgentsResp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cfgs := []*pbc.AgentConfig{}
+	names := []string{}
+	for name := range s.agents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cfgs = append(cfgs, cloneAgentConfig(s.agents[name]))
+	}
+	return &pbc.GetAllAgentsResp{Cfgs: cfgs}, nil
+}
+
+// AddAgent registers an agent, if there isn't already one with its name.
+func (s *Server) AddAgent(ctx context.Context, req *pbc.AddAgentReq) (*pbc.AddAgentResp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if req.Cfg == nil || req.Cfg.Name == "" {
+		return &pbc.AddAgentResp{Success: false, ErrorMsg: "agent name cannot be empty"}, nil
+	}
+	if _, ok := s.agents[req.Cfg.Name]; ok {
+		return &pbc.AddAgentResp{Success: false, ErrorMsg: fmt.Sprintf("agent with name %s is already registered", req.Cfg.Name)}, nil
+	}
+
+	s.agents[req.Cfg.Name] = cloneAgentConfig(req.Cfg)
+	s.logf("registered agent %s", req.Cfg.Name)
+	return &pbc.AddAgentResp{Success: true}, nil
+}
+
+// GetJobSetTemplate gets a registered job set template.
+func (s *Server) GetJobSetTemplate(ctx context.Context, req *pbc.GetJobSetTemplateReq) (*pbc.GetJobSetTemplateResp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jst, ok := s.templates[req.Name]
+	if !ok {
+		return &pbc.GetJobSetTemplateResp{Success: false, ErrorMsg: fmt.Sprintf("no job set template with name %s", req.Name)}, nil
+	}
+	return &pbc.GetJobSetTemplateResp{Success: true, Jst: jst}, nil
+}
+
+// GetAllJobSetTemplates gets all registered job set templates, sorted by
+// name.
+func (s *Server) GetAllJobSetTemplates(ctx context.Context, req *pbc.GetAllJobSetTemplatesReq) (*pbc.GetAllJobSetTemplatesResp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jsts := []*pbc.JobSetTemplate{}
+	names := []string{}
+	for name := range s.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		jsts = append(jsts, s.templates[name])
+	}
+	return &pbc.GetAllJobSetTemplatesResp{Jsts: jsts}, nil
+}
+
+// AddJobSetTemplate registers a job set template, if there isn't already
+// one with its name and all of the agents and templates its steps name
+// are registered.
+func (s *Server) AddJobSetTemplate(ctx context.Context, req *pbc.AddJobSetTemplateReq) (*pbc.AddJobSetTemplateResp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if req.Jst == nil || req.Jst.Name == "" {
+		return &pbc.AddJobSetTemplateResp{Success: false, ErrorMsg: "job set template name cannot be empty"}, nil
+	}
+	if _, ok := s.templates[req.Jst.Name]; ok {
+		return &pbc.AddJobSetTemplateResp{Success: false, ErrorMsg: fmt.Sprintf("job set template with name %s is already registered", req.Jst.Name)}, nil
+	}
+	if err := s.checkStepTemplates(req.Jst.Steps); err != nil {
+		return &pbc.AddJobSetTemplateResp{Success: false, ErrorMsg: err.Error()}, nil
+	}
+
+	// templates are never changed once registered, so they can be
+	// shared with responses as they are
+	s.templates[req.Jst.Name] = req.Jst
+	s.logf("registered job set template %s", req.Jst.Name)
+	return &pbc.AddJobSetTemplateResp{Success: true}, nil
+}
+
+func (s *Server) checkStepTemplates(steps []*pbc.StepTemplate) error {
+	for _, st := range steps {
+		switch x := st.S.(type) {
+		case *pbc.StepTemplate_Agent:
+			if _, ok := s.agents[x.Agent.Name]; !ok {
+				return fmt.Errorf("no agent with name %s", x.Agent.Name)
+			}
+		case *pbc.StepTemplate_Jobset:
+			if _, ok := s.templates[x.Jobset.Name]; !ok {
+				return fmt.Errorf("no job set template with name %s", x.Jobset.Name)
+			}
+		case *pbc.StepTemplate_Concurrent:
+			if err := s.checkStepTemplates(x.Concurrent.Steps); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("invalid step type")
+		}
+	}
+	return nil
+}
+
+// GetJobSet gets the details of a job set.
+func (s *Server) GetJobSet(ctx context.Context, req *pbc.GetJobSetReq) (*pbc.GetJobSetResp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jsd, ok := s.jobSets[req.JobSetID]
+	if !ok {
+		return &pbc.GetJobSetResp{Success: false, ErrorMsg: fmt.Sprintf("no job set with ID %d", req.JobSetID)}, nil
+	}
+	return &pbc.GetJobSetResp{Success: true, JobSet: cloneJobSet(jsd)}, nil
+}
+
+// GetAllJobSets gets the details of all job sets, sorted by ID.
+func (s *Server) GetAllJobSets(ctx context.Context, req *pbc.GetAllJobSetsReq) (*pbc.GetAllJobSetsResp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobSets := []*pbc.JobSetDetails{}
+	for _, id := range s.sortedJobSetIDs() {
+		jobSets = append(jobSets, cloneJobSet(s.jobSets[id]))
+	}
+	return &pbc.GetAllJobSetsResp{JobSets: jobSets}, nil
+}
+
+// StartJobSet starts a job set from a registered template. The
+// controller must be running.
+func (s *Server) StartJobSet(ctx context.Context, req *pbc.StartJobSetReq) (*pbc.StartJobSetResp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.runStatus != pbs.Status_RUNNING {
+		return &pbc.StartJobSetResp{Success: false, ErrorMsg: "controller is not running"}, nil
+	}
+	if _, ok := s.templates[req.JstName]; !ok {
+		return &pbc.StartJobSetResp{Success: false, ErrorMsg: fmt.Sprintf("no job set template with name %s", req.JstName)}, nil
+	}
+
+	id := s.newJobSet(req.JstName, req.Cfgs)
+	return &pbc.StartJobSetResp{Success: true, JobSetID: id}, nil
+}
+
+func (s *Server) sortedJobSetIDs() []uint64 {
+	ids := []uint64{}
+	for id := range s.jobSets {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
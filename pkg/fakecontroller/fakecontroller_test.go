@@ -0,0 +1,296 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package fakecontroller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pbc "github.com/swinslow/peridot-core/pkg/controller"
+	pbs "github.com/swinslow/peridot-core/pkg/status"
+)
+
+func agentTemplate(name string) *pbc.StepTemplate {
+	return &pbc.StepTemplate{S: &pbc.StepTemplate_Agent{Agent: &pbc.StepAgentTemplate{Name: name}}}
+}
+
+func jobSetTemplate(name string) *pbc.StepTemplate {
+	return &pbc.StepTemplate{S: &pbc.StepTemplate_Jobset{Jobset: &pbc.StepJobSetTemplate{Name: name}}}
+}
+
+func concurrentTemplate(steps ...*pbc.StepTemplate) *pbc.StepTemplate {
+	return &pbc.StepTemplate{S: &pbc.StepTemplate_Concurrent{Concurrent: &pbc.StepConcurrentTemplate{Steps: steps}}}
+}
+
+// newTestServer returns a fake controller with agents "ok", "degraded"
+// and "error", whose jobs finish with that health, and templates using
+// them. Its clock moves forward one second each time Advance is
+// called, starting at 2020-01-01T00:00:00Z.
+func newTestServer(t *testing.T, started bool) *Server {
+	t.Helper()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := New(Options{
+		Started: started,
+		Now: func() time.Time {
+			now = now.Add(time.Second)
+			return now
+		},
+	})
+
+	ctx := context.Background()
+	for _, name := range []string{"ok", "degraded", "error"} {
+		resp, _ := s.AddAgent(ctx, &pbc.AddAgentReq{Cfg: &pbc.AgentConfig{
+			Name: name,
+			Url:  "localhost",
+			Port: 9001,
+			Type: name,
+			Kvs:  []*pbc.AgentConfig_AgentKV{{Key: fakeResultKey, Value: name}},
+		}})
+		if !resp.Success {
+			t.Fatalf("could not add agent %s: %s", name, resp.ErrorMsg)
+		}
+	}
+
+	for _, jst := range []*pbc.JobSetTemplate{
+		{Name: "one", Steps: []*pbc.StepTemplate{agentTemplate("ok")}},
+		{Name: "degraded", Steps: []*pbc.StepTemplate{agentTemplate("ok"), agentTemplate("degraded")}},
+		{Name: "failing", Steps: []*pbc.StepTemplate{agentTemplate("error"), agentTemplate("ok")}},
+		{Name: "concurrent", Steps: []*pbc.StepTemplate{concurrentTemplate(agentTemplate("ok"), agentTemplate("degraded"))}},
+		{Name: "nested", Steps: []*pbc.StepTemplate{jobSetTemplate("one")}},
+	} {
+		resp, _ := s.AddJobSetTemplate(ctx, &pbc.AddJobSetTemplateReq{Jst: jst})
+		if !resp.Success {
+			t.Fatalf("could not add template %s: %s", jst.Name, resp.ErrorMsg)
+		}
+	}
+
+	return s
+}
+
+func getJobSet(t *testing.T, s *Server, id uint64) *pbc.JobSetDetails {
+	t.Helper()
+	resp, _ := s.GetJobSet(context.Background(), &pbc.GetJobSetReq{JobSetID: id})
+	if !resp.Success {
+		t.Fatalf("could not get job set %d: %s", id, resp.ErrorMsg)
+	}
+	return resp.JobSet
+}
+
+func TestStartStop(t *testing.T) {
+	s := New(Options{})
+	ctx := context.Background()
+
+	steps := []struct {
+		name         string
+		call         func() string
+		wantErrorMsg string
+		wantStatus   pbs.Status
+	}{
+		{"start", func() string {
+			resp, _ := s.Start(ctx, &pbc.StartReq{})
+			return resp.ErrorMsg
+		}, "", pbs.Status_RUNNING},
+		{"start again", func() string {
+			resp, _ := s.Start(ctx, &pbc.StartReq{})
+			return resp.ErrorMsg
+		}, "controller is already running", pbs.Status_RUNNING},
+		{"stop", func() string {
+			s.Stop(ctx, &pbc.StopReq{})
+			return ""
+		}, "", pbs.Status_STOPPED},
+		{"start after stop", func() string {
+			resp, _ := s.Start(ctx, &pbc.StartReq{})
+			return resp.ErrorMsg
+		}, "", pbs.Status_RUNNING},
+	}
+
+	if st, _ := s.GetStatus(ctx, &pbc.GetStatusReq{}); st.RunStatus != pbs.Status_STARTUP {
+		t.Fatalf("got initial status %s, want STARTUP", st.RunStatus)
+	}
+	for _, step := range steps {
+		if msg := step.call(); msg != step.wantErrorMsg {
+			t.Errorf("%s: got error message %q, want %q", step.name, msg, step.wantErrorMsg)
+		}
+		if st, _ := s.GetStatus(ctx, &pbc.GetStatusReq{}); st.RunStatus != step.wantStatus {
+			t.Errorf("%s: got status %s, want %s", step.name, st.RunStatus, step.wantStatus)
+		}
+	}
+}
+
+func TestStartJobSetRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		started  bool
+		template string
+		want     string
+	}{
+		{"not running", false, "one", "controller is not running"},
+		{"unknown template", true, "nosuchtemplate", "no job set template with name nosuchtemplate"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, tc.started)
+			resp, err := s.StartJobSet(context.Background(), &pbc.StartJobSetReq{JstName: tc.template})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Success || resp.ErrorMsg != tc.want {
+				t.Errorf("got success %v and error message %q, want %q", resp.Success, resp.ErrorMsg, tc.want)
+			}
+			if all, _ := s.GetAllJobSets(context.Background(), &pbc.GetAllJobSetsReq{}); len(all.JobSets) != 0 {
+				t.Errorf("got %d job sets, want none", len(all.JobSets))
+			}
+		})
+	}
+}
+
+func TestAddRejected(t *testing.T) {
+	s := newTestServer(t, true)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call func() (bool, string)
+		want string
+	}{
+		{"agent with no name", func() (bool, string) {
+			resp, _ := s.AddAgent(ctx, &pbc.AddAgentReq{Cfg: &pbc.AgentConfig{}})
+			return resp.Success, resp.ErrorMsg
+		}, "agent name cannot be empty"},
+		{"duplicate agent", func() (bool, string) {
+			resp, _ := s.AddAgent(ctx, &pbc.AddAgentReq{Cfg: &pbc.AgentConfig{Name: "ok"}})
+			return resp.Success, resp.ErrorMsg
+		}, "agent with name ok is already registered"},
+		{"duplicate template", func() (bool, string) {
+			resp, _ := s.AddJobSetTemplate(ctx, &pbc.AddJobSetTemplateReq{Jst: &pbc.JobSetTemplate{Name: "one"}})
+			return resp.Success, resp.ErrorMsg
+		}, "job set template with name one is already registered"},
+		{"template with unknown agent", func() (bool, string) {
+			resp, _ := s.AddJobSetTemplate(ctx, &pbc.AddJobSetTemplateReq{Jst: &pbc.JobSetTemplate{
+				Name:  "new",
+				Steps: []*pbc.StepTemplate{concurrentTemplate(agentTemplate("nosuchagent"))},
+			}})
+			return resp.Success, resp.ErrorMsg
+		}, "no agent with name nosuchagent"},
+		{"template with unknown template", func() (bool, string) {
+			resp, _ := s.AddJobSetTemplate(ctx, &pbc.AddJobSetTemplateReq{Jst: &pbc.JobSetTemplate{
+				Name:  "new",
+				Steps: []*pbc.StepTemplate{jobSetTemplate("nosuchtemplate")},
+			}})
+			return resp.Success, resp.ErrorMsg
+		}, "no job set template with name nosuchtemplate"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if ok, msg := tc.call(); ok || msg != tc.want {
+				t.Errorf("got success %v and error message %q, want %q", ok, msg, tc.want)
+			}
+		})
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	tests := []struct {
+		template string
+		// steps is how many calls to Advance it takes to stop
+		steps      int
+		wantHealth pbs.Health
+	}{
+		// running, agent running, agent stopped, job set stopped
+		{"one", 4, pbs.Health_OK},
+		{"degraded", 6, pbs.Health_DEGRADED},
+		// stops after the first step fails
+		{"failing", 4, pbs.Health_ERROR},
+		// concurrent steps move together
+		{"concurrent", 4, pbs.Health_DEGRADED},
+		// the sub-job set is created when the jobset step starts, and
+		// only moves on the next call
+		{"nested", 8, pbs.Health_OK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.template, func(t *testing.T) {
+			s := newTestServer(t, true)
+			resp, _ := s.StartJobSet(context.Background(), &pbc.StartJobSetReq{JstName: tc.template})
+			if !resp.Success {
+				t.Fatalf("could not start job set: %s", resp.ErrorMsg)
+			}
+
+			jsd := getJobSet(t, s, resp.JobSetID)
+			if jsd.St.RunStatus != pbs.Status_STARTUP || jsd.St.TimeStarted != 0 {
+				t.Fatalf("got new job set %s started at %d, want STARTUP and not started", jsd.St.RunStatus, jsd.St.TimeStarted)
+			}
+
+			for i := 1; i <= tc.steps; i++ {
+				s.Advance()
+				jsd = getJobSet(t, s, resp.JobSetID)
+				if i == 1 && (jsd.St.RunStatus != pbs.Status_RUNNING || jsd.St.TimeStarted == 0) {
+					t.Errorf("after 1 step: got %s started at %d, want RUNNING and started", jsd.St.RunStatus, jsd.St.TimeStarted)
+				}
+				if i < tc.steps && jsd.St.RunStatus == pbs.Status_STOPPED {
+					t.Fatalf("stopped after %d steps, want %d", i, tc.steps)
+				}
+			}
+
+			if jsd.St.RunStatus != pbs.Status_STOPPED {
+				t.Fatalf("got %s after %d steps, want STOPPED", jsd.St.RunStatus, tc.steps)
+			}
+			if jsd.St.HealthStatus != tc.wantHealth {
+				t.Errorf("got health %s, want %s", jsd.St.HealthStatus, tc.wantHealth)
+			}
+			if jsd.St.TimeFinished <= jsd.St.TimeStarted {
+				t.Errorf("got finish time %d, want after start time %d", jsd.St.TimeFinished, jsd.St.TimeStarted)
+			}
+
+			// stopped job sets don't move any further
+			s.Advance()
+			if again := getJobSet(t, s, resp.JobSetID); again.St.TimeFinished != jsd.St.TimeFinished {
+				t.Errorf("got finish time %d after another step, want %d", again.St.TimeFinished, jsd.St.TimeFinished)
+			}
+		})
+	}
+}
+
+func TestAdvanceNestedJobSet(t *testing.T) {
+	s := newTestServer(t, true)
+	resp, _ := s.StartJobSet(context.Background(), &pbc.StartJobSetReq{JstName: "nested"})
+
+	s.Advance()
+	s.Advance()
+	step := getJobSet(t, s, resp.JobSetID).Steps[0].S.(*pbc.Step_Jobset).Jobset
+	if step.JobSetID == 0 {
+		t.Fatalf("got no sub-job set once the jobset step is running")
+	}
+	if sub := getJobSet(t, s, step.JobSetID); sub.TemplateName != "one" || sub.St.RunStatus != pbs.Status_STARTUP {
+		t.Errorf("got sub-job set for %s with status %s, want one and STARTUP", sub.TemplateName, sub.St.RunStatus)
+	}
+}
+
+func TestAdvanceNotRunning(t *testing.T) {
+	s := newTestServer(t, true)
+	ctx := context.Background()
+	resp, _ := s.StartJobSet(ctx, &pbc.StartJobSetReq{JstName: "one"})
+	s.Advance()
+	s.Stop(ctx, &pbc.StopReq{})
+
+	s.Advance()
+	s.Advance()
+	jsd := getJobSet(t, s, resp.JobSetID)
+	if jsd.St.RunStatus != pbs.Status_RUNNING || jsd.Steps[0].RunStatus != pbs.Status_STARTUP {
+		t.Errorf("got job set %s and step %s while stopped, want RUNNING and STARTUP", jsd.St.RunStatus, jsd.Steps[0].RunStatus)
+	}
+}
+
+func TestGetJobSetCopies(t *testing.T) {
+	s := newTestServer(t, true)
+	resp, _ := s.StartJobSet(context.Background(), &pbc.StartJobSetReq{JstName: "one"})
+
+	jsd := getJobSet(t, s, resp.JobSetID)
+	s.Advance()
+	if jsd.St.RunStatus != pbs.Status_STARTUP {
+		t.Errorf("got %s for details fetched before Advance, want STARTUP", jsd.St.RunStatus)
+	}
+}
@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package fakecontroller
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+
+	pbc "github.com/swinslow/peridot-core/pkg/controller"
+	"github.com/swinslow/peridotctl/pkg/client"
+)
+
+// bufSize is the buffer size for in-memory connections.
+const bufSize = 1024 * 1024
+
+// Serve serves the fake controller's gRPC API on lis, and moves job
+// sets forward every StepInterval if it is set. It blocks until
+// Shutdown is called or lis fails.
+func (s *Server) Serve(lis net.Listener) error {
+	return s.start().Serve(lis)
+}
+
+// start sets up the gRPC server and the ticker, so that Shutdown can
+// stop them once this returns.
+func (s *Server) start() *grpc.Server {
+	gs := grpc.NewServer()
+	pbc.RegisterControllerServer(gs, s)
+
+	stopTicker := make(chan struct{})
+	s.mu.Lock()
+	s.stopTicker = stopTicker
+	s.grpcStop = gs.Stop
+	s.mu.Unlock()
+
+	if s.opts.StepInterval > 0 {
+		go s.tick(s.opts.StepInterval, stopTicker)
+	}
+
+	return gs
+}
+
+func (s *Server) tick(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.Advance()
+		}
+	}
+}
+
+// Shutdown stops serving and moving job sets forward. The fake
+// controller's state is kept, and it can be served again.
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	stopTicker, grpcStop := s.stopTicker, s.grpcStop
+	s.stopTicker, s.grpcStop = nil, nil
+	s.mu.Unlock()
+
+	if stopTicker != nil {
+		close(stopTicker)
+	}
+	if grpcStop != nil {
+		grpcStop()
+	}
+}
+
+// NewBufconnClient serves the fake controller over an in-memory
+// connection, and returns a Client connected to it. Calling Shutdown
+// stops serving; the Client should be closed separately.
+func (s *Server) NewBufconnClient(dialOpts ...grpc.DialOption) (*client.Client, error) {
+	lis := bufconn.Listen(bufSize)
+	gs := s.start()
+	go gs.Serve(lis)
+
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		return lis.Dial()
+	}
+	return client.New(client.Options{
+		Address:     "bufconn",
+		DialOptions: append([]grpc.DialOption{grpc.WithContextDialer(dialer)}, dialOpts...),
+	})
+}
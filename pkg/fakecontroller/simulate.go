@@ -0,0 +1,255 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package fakecontroller
+
+import (
+	pbc "github.com/swinslow/peridot-core/pkg/controller"
+	pbs "github.com/swinslow/peridot-core/pkg/status"
+)
+
+// fakeResultKey is the agent config key that sets the health its jobs
+// finish with.
+const fakeResultKey = "fake-result"
+
+// Advance moves every job set that is not yet stopped forward by one
+// step, if the controller is running. A job set first moves to running,
+// then each of its steps in turn moves to running and then to stopped.
+// The steps in a concurrent step all move forward together, and a
+// jobset step stops once the job set it started has stopped.
+func (s *Server) Advance() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.runStatus != pbs.Status_RUNNING {
+		return
+	}
+
+	// job sets started by jobset steps during this call have higher IDs
+	// than any in this list, so they don't move until the next call
+	for _, id := range s.sortedJobSetIDs() {
+		jsd := s.jobSets[id]
+		if jsd.St.RunStatus != pbs.Status_STOPPED {
+			s.advanceJobSet(jsd)
+		}
+	}
+}
+
+func (s *Server) newJobSet(templateName string, cfgs []*pbc.JobSetConfig) uint64 {
+	id := s.nextJSID
+	s.nextJSID++
+
+	jsd := &pbc.JobSetDetails{
+		JobSetID:     id,
+		TemplateName: templateName,
+		St: &pbc.StatusReport{
+			RunStatus:    pbs.Status_STARTUP,
+			HealthStatus: pbs.Health_OK,
+		},
+		Cfgs:  cfgs,
+		Steps: s.newSteps(id, s.templates[templateName].Steps),
+	}
+	s.jobSets[id] = jsd
+	s.logf("created job set %d for template %s", id, templateName)
+	return id
+}
+
+func (s *Server) newSteps(jobSetID uint64, templates []*pbc.StepTemplate) []*pbc.Step {
+	steps := []*pbc.Step{}
+	for i, st := range templates {
+		step := &pbc.Step{
+			StepID:       s.nextStep,
+			JobSetID:     jobSetID,
+			StepOrder:    uint64(i + 1),
+			RunStatus:    pbs.Status_STARTUP,
+			HealthStatus: pbs.Health_OK,
+		}
+		s.nextStep++
+
+		switch x := st.S.(type) {
+		case *pbc.StepTemplate_Agent:
+			step.S = &pbc.Step_Agent{Agent: &pbc.StepAgent{AgentName: x.Agent.Name}}
+		case *pbc.StepTemplate_Jobset:
+			step.S = &pbc.Step_Jobset{Jobset: &pbc.StepJobSet{TemplateName: x.Jobset.Name}}
+		case *pbc.StepTemplate_Concurrent:
+			step.S = &pbc.Step_Concurrent{Concurrent: &pbc.StepConcurrent{Steps: s.newSteps(jobSetID, x.Concurrent.Steps)}}
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+func (s *Server) advanceJobSet(jsd *pbc.JobSetDetails) {
+	now := s.opts.Now().Unix()
+
+	if jsd.St.RunStatus == pbs.Status_STARTUP {
+		jsd.St.RunStatus = pbs.Status_RUNNING
+		jsd.St.TimeStarted = now
+		s.logf("job set %d is running", jsd.JobSetID)
+		return
+	}
+
+	done, health := s.advanceSequence(jsd, jsd.Steps)
+	if !done {
+		return
+	}
+
+	jsd.St.RunStatus = pbs.Status_STOPPED
+	jsd.St.HealthStatus = health
+	jsd.St.TimeFinished = now
+	if health == pbs.Health_ERROR {
+		jsd.St.ErrorMessages = "a step failed"
+	} else {
+		jsd.St.OutputMessages = "all steps completed"
+	}
+	s.logf("job set %d stopped with health %s", jsd.JobSetID, health.String())
+}
+
+// advanceSequence moves the first step in steps that is not stopped
+// forward. It returns true once all steps have stopped, or a step has
+// stopped with health ERROR, along with the worst health of the
+// stopped steps.
+func (s *Server) advanceSequence(jsd *pbc.JobSetDetails, steps []*pbc.Step) (bool, pbs.Health) {
+	health := pbs.Health_OK
+	for _, step := range steps {
+		if step.RunStatus == pbs.Status_STOPPED {
+			health = worseHealth(health, step.HealthStatus)
+			if step.HealthStatus == pbs.Health_ERROR {
+				return true, health
+			}
+			continue
+		}
+
+		s.advanceStep(jsd, step)
+		return false, health
+	}
+	return true, health
+}
+
+func (s *Server) advanceStep(jsd *pbc.JobSetDetails, step *pbc.Step) {
+	switch x := step.S.(type) {
+	case *pbc.Step_Agent:
+		if step.RunStatus == pbs.Status_STARTUP {
+			step.RunStatus = pbs.Status_RUNNING
+			x.Agent.JobID = s.nextJobID
+			s.nextJobID++
+			return
+		}
+		step.RunStatus = pbs.Status_STOPPED
+		step.HealthStatus = s.agentResult(x.Agent.AgentName)
+
+	case *pbc.Step_Jobset:
+		if step.RunStatus == pbs.Status_STARTUP {
+			step.RunStatus = pbs.Status_RUNNING
+			x.Jobset.JobSetID = s.newJobSet(x.Jobset.TemplateName, jsd.Cfgs)
+			return
+		}
+		sub := s.jobSets[x.Jobset.JobSetID]
+		if sub.St.RunStatus == pbs.Status_STOPPED {
+			step.RunStatus = pbs.Status_STOPPED
+			step.HealthStatus = sub.St.HealthStatus
+		}
+
+	case *pbc.Step_Concurrent:
+		step.RunStatus = pbs.Status_RUNNING
+		done := true
+		health := pbs.Health_OK
+		for _, sub := range x.Concurrent.Steps {
+			if sub.RunStatus != pbs.Status_STOPPED {
+				s.advanceStep(jsd, sub)
+			}
+			if sub.RunStatus != pbs.Status_STOPPED {
+				done = false
+			}
+			health = worseHealth(health, sub.HealthStatus)
+		}
+		if done {
+			step.RunStatus = pbs.Status_STOPPED
+			step.HealthStatus = health
+		}
+	}
+}
+
+// agentResult gets the health that the named agent's jobs finish with.
+func (s *Server) agentResult(name string) pbs.Health {
+	ac, ok := s.agents[name]
+	if !ok {
+		return pbs.Health_ERROR
+	}
+	for _, kv := range ac.Kvs {
+		if kv.Key != fakeResultKey {
+			continue
+		}
+		switch kv.Value {
+		case "degraded":
+			return pbs.Health_DEGRADED
+		case "error":
+			return pbs.Health_ERROR
+		}
+	}
+	return pbs.Health_OK
+}
+
+// worseHealth returns whichever of a and b is worse. The Health values
+// are ordered from best to worst.
+func worseHealth(a, b pbs.Health) pbs.Health {
+	if b > a {
+		return b
+	}
+	return a
+}
+
+func cloneAgentConfig(ac *pbc.AgentConfig) *pbc.AgentConfig {
+	kvs := []*pbc.AgentConfig_AgentKV{}
+	for _, kv := range ac.Kvs {
+		kvs = append(kvs, &pbc.AgentConfig_AgentKV{Key: kv.Key, Value: kv.Value})
+	}
+	return &pbc.AgentConfig{
+		Name: ac.Name,
+		Url:  ac.Url,
+		Port: ac.Port,
+		Type: ac.Type,
+		Kvs:  kvs,
+	}
+}
+
+// cloneJobSet copies a job set's details, so that they can be sent
+// without holding the lock while Advance changes the original.
+func cloneJobSet(jsd *pbc.JobSetDetails) *pbc.JobSetDetails {
+	return &pbc.JobSetDetails{
+		JobSetID:     jsd.JobSetID,
+		TemplateName: jsd.TemplateName,
+		St: &pbc.StatusReport{
+			RunStatus:      jsd.St.RunStatus,
+			HealthStatus:   jsd.St.HealthStatus,
+			TimeStarted:    jsd.St.TimeStarted,
+			TimeFinished:   jsd.St.TimeFinished,
+			OutputMessages: jsd.St.OutputMessages,
+			ErrorMessages:  jsd.St.ErrorMessages,
+		},
+		Cfgs:  jsd.Cfgs,
+		Steps: cloneSteps(jsd.Steps),
+	}
+}
+
+func cloneSteps(steps []*pbc.Step) []*pbc.Step {
+	clones := []*pbc.Step{}
+	for _, step := range steps {
+		clone := &pbc.Step{
+			StepID:       step.StepID,
+			JobSetID:     step.JobSetID,
+			StepOrder:    step.StepOrder,
+			RunStatus:    step.RunStatus,
+			HealthStatus: step.HealthStatus,
+		}
+		switch x := step.S.(type) {
+		case *pbc.Step_Agent:
+			clone.S = &pbc.Step_Agent{Agent: &pbc.StepAgent{AgentName: x.Agent.AgentName, JobID: x.Agent.JobID}}
+		case *pbc.Step_Jobset:
+			clone.S = &pbc.Step_Jobset{Jobset: &pbc.StepJobSet{TemplateName: x.Jobset.TemplateName, JobSetID: x.Jobset.JobSetID}}
+		case *pbc.Step_Concurrent:
+			clone.S = &pbc.Step_Concurrent{Concurrent: &pbc.StepConcurrent{Steps: cloneSteps(x.Concurrent.Steps)}}
+		}
+		clones = append(clones, clone)
+	}
+	return clones
+}
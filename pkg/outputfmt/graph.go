@@ -0,0 +1,363 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package outputfmt
+
+import (
+	"fmt"
+	"strings"
+
+	pbc "github.com/swinslow/peridot-core/pkg/controller"
+	pbs "github.com/swinslow/peridot-core/pkg/status"
+)
+
+// Graph formats supported by Graph.Render.
+const (
+	GraphDOT     = "dot"
+	GraphMermaid = "mermaid"
+)
+
+// node shapes
+const (
+	shapeStep = iota
+	shapeTerminal
+	shapePoint
+)
+
+type graphNode struct {
+	id    string
+	label string
+	shape int
+	color string
+}
+
+type graphCluster struct {
+	id       string
+	label    string
+	color    string
+	nodes    []*graphNode
+	clusters []*graphCluster
+}
+
+// Graph is a flowchart of the steps in a job set or job set template,
+// which can be rendered as Graphviz DOT or a Mermaid flowchart.
+// Sequential steps are joined by edges, concurrent steps fan out from
+// and back in to a point, and jobset steps are expanded into a
+// subgraph of their own steps where possible.
+type Graph struct {
+	root   *graphCluster
+	edges  [][2]string
+	nextID int
+}
+
+// TemplateGraph builds a Graph for the named job set template. The
+// templates map is used to expand jobset steps into the steps of the
+// templates they refer to.
+func TemplateGraph(name string, templates map[string]*pbc.JobSetTemplate) (*Graph, error) {
+	jst, ok := templates[name]
+	if !ok {
+		return nil, fmt.Errorf("job set template %s not found", name)
+	}
+
+	g := newGraph(name)
+	entries, exits := g.addStepTemplates(g.root, jst.Steps, templates, map[string]bool{name: true})
+	g.connectEnds(entries, exits)
+	return g, nil
+}
+
+// JobSetGraph builds a Graph for a job set, with each step colored by
+// its status. getJobSet is used to look up the job sets started by
+// jobset steps so they can be expanded; if it fails, the step is shown
+// without expanding it.
+func JobSetGraph(jsd *pbc.JobSetDetails, getJobSet func(id uint64) (*pbc.JobSetDetails, error)) *Graph {
+	g := newGraph(fmt.Sprintf("job set %d: %s", jsd.JobSetID, jsd.TemplateName))
+	g.root.color = statusColor(jsd.St.RunStatus, jsd.St.HealthStatus)
+	entries, exits := g.addSteps(g.root, jsd.Steps, getJobSet, map[uint64]bool{jsd.JobSetID: true})
+	g.connectEnds(entries, exits)
+	return g
+}
+
+// Render returns the graph in the requested format, either GraphDOT or
+// GraphMermaid.
+func (g *Graph) Render(format string) (string, error) {
+	switch format {
+	case GraphDOT:
+		return g.renderDOT(), nil
+	case GraphMermaid:
+		return g.renderMermaid(), nil
+	default:
+		return "", fmt.Errorf("unknown graph format %s, expected %s or %s", format, GraphDOT, GraphMermaid)
+	}
+}
+
+func newGraph(label string) *Graph {
+	return &Graph{root: &graphCluster{id: "root", label: label}}
+}
+
+func (g *Graph) newID(prefix string) string {
+	g.nextID++
+	return fmt.Sprintf("%s%d", prefix, g.nextID)
+}
+
+func (g *Graph) addNode(c *graphCluster, label string, shape int, color string) string {
+	n := &graphNode{id: g.newID("n"), label: label, shape: shape, color: color}
+	c.nodes = append(c.nodes, n)
+	return n.id
+}
+
+func (g *Graph) addCluster(c *graphCluster, label string, color string) *graphCluster {
+	sub := &graphCluster{id: g.newID("cluster_"), label: label, color: color}
+	c.clusters = append(c.clusters, sub)
+	return sub
+}
+
+func (g *Graph) connect(froms []string, tos []string) {
+	for _, from := range froms {
+		for _, to := range tos {
+			g.edges = append(g.edges, [2]string{from, to})
+		}
+	}
+}
+
+// connectEnds adds start and end nodes around the top-level steps.
+func (g *Graph) connectEnds(entries []string, exits []string) {
+	start := g.addNode(g.root, "start", shapeTerminal, "")
+	end := g.addNode(g.root, "end", shapeTerminal, "")
+	if len(entries) == 0 {
+		g.connect([]string{start}, []string{end})
+		return
+	}
+	g.connect([]string{start}, entries)
+	g.connect(exits, []string{end})
+}
+
+// addSequence adds each step in turn using addStep, joining each one's
+// exits to the next one's entries, and returns the sequence's entries
+// and exits. Steps that add nothing to join, such as unknown step
+// types, are passed over, so that the steps either side of them are
+// still joined.
+func (g *Graph) addSequence(n int, addStep func(i int) ([]string, []string)) ([]string, []string) {
+	var entries, exits []string
+	for i := 0; i < n; i++ {
+		stepEntries, stepExits := addStep(i)
+		if len(stepEntries) == 0 {
+			continue
+		}
+		if len(entries) == 0 {
+			entries = stepEntries
+		} else {
+			g.connect(exits, stepEntries)
+		}
+		exits = stepExits
+	}
+	return entries, exits
+}
+
+// addConcurrent adds a fork and join point around n concurrent steps.
+// A step that adds nothing, or an empty group, joins the fork straight
+// to the join.
+func (g *Graph) addConcurrent(c *graphCluster, n int, color string, addStep func(i int) ([]string, []string)) ([]string, []string) {
+	fork := g.addNode(c, "fork", shapePoint, color)
+	join := g.addNode(c, "join", shapePoint, color)
+	direct := n == 0
+	for i := 0; i < n; i++ {
+		stepEntries, stepExits := addStep(i)
+		if len(stepEntries) == 0 {
+			direct = true
+			continue
+		}
+		g.connect([]string{fork}, stepEntries)
+		g.connect(stepExits, []string{join})
+	}
+	if direct {
+		g.connect([]string{fork}, []string{join})
+	}
+	return []string{fork}, []string{join}
+}
+
+func (g *Graph) addStepTemplates(c *graphCluster, steps []*pbc.StepTemplate, templates map[string]*pbc.JobSetTemplate, expanding map[string]bool) ([]string, []string) {
+	return g.addSequence(len(steps), func(i int) ([]string, []string) {
+		switch x := steps[i].S.(type) {
+		case *pbc.StepTemplate_Agent:
+			n := g.addNode(c, "agent: "+x.Agent.Name, shapeStep, "")
+			return []string{n}, []string{n}
+		case *pbc.StepTemplate_Jobset:
+			label := "jobset: " + x.Jobset.Name
+			sub, ok := templates[x.Jobset.Name]
+			if !ok || expanding[x.Jobset.Name] || len(sub.Steps) == 0 {
+				n := g.addNode(c, label, shapeStep, "")
+				return []string{n}, []string{n}
+			}
+			expanding[x.Jobset.Name] = true
+			defer delete(expanding, x.Jobset.Name)
+			return g.addStepTemplates(g.addCluster(c, label, ""), sub.Steps, templates, expanding)
+		case *pbc.StepTemplate_Concurrent:
+			subSteps := x.Concurrent.Steps
+			return g.addConcurrent(c, len(subSteps), "", func(j int) ([]string, []string) {
+				return g.addStepTemplates(c, subSteps[j:j+1], templates, expanding)
+			})
+		}
+		return nil, nil
+	})
+}
+
+func (g *Graph) addSteps(c *graphCluster, steps []*pbc.Step, getJobSet func(id uint64) (*pbc.JobSetDetails, error), expanding map[uint64]bool) ([]string, []string) {
+	return g.addSequence(len(steps), func(i int) ([]string, []string) {
+		step := steps[i]
+		color := statusColor(step.RunStatus, step.HealthStatus)
+		switch x := step.S.(type) {
+		case *pbc.Step_Agent:
+			label := fmt.Sprintf("agent: %s\njob %d", x.Agent.AgentName, x.Agent.JobID)
+			n := g.addNode(c, label, shapeStep, color)
+			return []string{n}, []string{n}
+		case *pbc.Step_Jobset:
+			label := fmt.Sprintf("jobset: %s\njob set %d", x.Jobset.TemplateName, x.Jobset.JobSetID)
+			var sub *pbc.JobSetDetails
+			if getJobSet != nil && !expanding[x.Jobset.JobSetID] {
+				sub, _ = getJobSet(x.Jobset.JobSetID)
+			}
+			if sub == nil || len(sub.Steps) == 0 {
+				n := g.addNode(c, label, shapeStep, color)
+				return []string{n}, []string{n}
+			}
+			expanding[x.Jobset.JobSetID] = true
+			defer delete(expanding, x.Jobset.JobSetID)
+			return g.addSteps(g.addCluster(c, label, color), sub.Steps, getJobSet, expanding)
+		case *pbc.Step_Concurrent:
+			subSteps := x.Concurrent.Steps
+			return g.addConcurrent(c, len(subSteps), color, func(j int) ([]string, []string) {
+				return g.addSteps(c, subSteps[j:j+1], getJobSet, expanding)
+			})
+		}
+		return nil, nil
+	})
+}
+
+// statusColor picks a fill color for a step with the given status.
+func statusColor(runStatus pbs.Status, health pbs.Health) string {
+	switch health {
+	case pbs.Health_ERROR:
+		return "#f4a6a6"
+	case pbs.Health_DEGRADED:
+		return "#f7d08a"
+	}
+	switch runStatus {
+	case pbs.Status_STARTUP:
+		return "#fff3b0"
+	case pbs.Status_RUNNING:
+		return "#a6c8f4"
+	case pbs.Status_STOPPED:
+		return "#b5e3a8"
+	}
+	return "#e0e0e0"
+}
+
+func (g *Graph) renderDOT() string {
+	lines := []string{
+		"digraph peridot {",
+		fmt.Sprintf("  label=%s;", dotQuote(g.root.label)),
+		"  labelloc=t;",
+		"  node [shape=box, style=rounded];",
+	}
+	if g.root.color != "" {
+		lines = append(lines, fmt.Sprintf("  bgcolor=%s;", dotQuote(g.root.color)))
+	}
+	lines = append(lines, g.root.dotBody(2)...)
+	for _, e := range g.edges {
+		lines = append(lines, fmt.Sprintf("  %s -> %s;", e[0], e[1]))
+	}
+	lines = append(lines, "}")
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func (c *graphCluster) dotBody(indent int) []string {
+	pad := strings.Repeat(" ", indent)
+	lines := []string{}
+
+	for _, n := range c.nodes {
+		attrs := []string{}
+		switch n.shape {
+		case shapeTerminal:
+			attrs = append(attrs, "shape=ellipse", "style=solid", "label="+dotQuote(n.label))
+		case shapePoint:
+			attrs = append(attrs, "shape=point", "width=0.1", "label="+dotQuote(""))
+		default:
+			attrs = append(attrs, "label="+dotQuote(n.label))
+			if n.color != "" {
+				attrs = append(attrs, `style="rounded,filled"`, "fillcolor="+dotQuote(n.color))
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%s%s [%s];", pad, n.id, strings.Join(attrs, ", ")))
+	}
+
+	for _, sub := range c.clusters {
+		lines = append(lines, fmt.Sprintf("%ssubgraph %s {", pad, sub.id))
+		lines = append(lines, fmt.Sprintf("%s  label=%s;", pad, dotQuote(sub.label)))
+		if sub.color != "" {
+			lines = append(lines, fmt.Sprintf("%s  style=filled;", pad))
+			lines = append(lines, fmt.Sprintf("%s  fillcolor=%s;", pad, dotQuote(sub.color)))
+		}
+		lines = append(lines, sub.dotBody(indent+2)...)
+		lines = append(lines, pad+"}")
+	}
+
+	return lines
+}
+
+func dotQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + s + `"`
+}
+
+func (g *Graph) renderMermaid() string {
+	lines := []string{
+		"---",
+		"title: " + dotQuote(strings.Replace(g.root.label, "\n", " ", -1)),
+		"---",
+		"flowchart TD",
+	}
+	styles := []string{}
+	lines = append(lines, g.root.mermaidBody(2, &styles)...)
+	for _, e := range g.edges {
+		lines = append(lines, fmt.Sprintf("  %s --> %s", e[0], e[1]))
+	}
+	lines = append(lines, styles...)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func (c *graphCluster) mermaidBody(indent int, styles *[]string) []string {
+	pad := strings.Repeat(" ", indent)
+	lines := []string{}
+
+	for _, n := range c.nodes {
+		switch n.shape {
+		case shapeTerminal:
+			lines = append(lines, fmt.Sprintf("%s%s([%s])", pad, n.id, mermaidQuote(n.label)))
+		case shapePoint:
+			lines = append(lines, fmt.Sprintf("%s%s((\" \"))", pad, n.id))
+		default:
+			lines = append(lines, fmt.Sprintf("%s%s[%s]", pad, n.id, mermaidQuote(n.label)))
+		}
+		if n.color != "" && n.shape != shapeTerminal {
+			*styles = append(*styles, fmt.Sprintf("  style %s fill:%s", n.id, n.color))
+		}
+	}
+
+	for _, sub := range c.clusters {
+		lines = append(lines, fmt.Sprintf("%ssubgraph %s [%s]", pad, sub.id, mermaidQuote(sub.label)))
+		lines = append(lines, sub.mermaidBody(indent+2, styles)...)
+		lines = append(lines, pad+"end")
+		if sub.color != "" {
+			*styles = append(*styles, fmt.Sprintf("  style %s fill:%s", sub.id, sub.color))
+		}
+	}
+
+	return lines
+}
+
+func mermaidQuote(s string) string {
+	s = strings.Replace(s, `"`, "#quot;", -1)
+	s = strings.Replace(s, "\n", "<br>", -1)
+	return `"` + s + `"`
+}
@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package outputfmt
+
+import (
+	"testing"
+
+	pbc "github.com/swinslow/peridot-core/pkg/controller"
+)
+
+func agentTemplate(name string) *pbc.StepTemplate {
+	return &pbc.StepTemplate{S: &pbc.StepTemplate_Agent{Agent: &pbc.StepAgentTemplate{Name: name}}}
+}
+
+func jobSetTemplateStep(name string) *pbc.StepTemplate {
+	return &pbc.StepTemplate{S: &pbc.StepTemplate_Jobset{Jobset: &pbc.StepJobSetTemplate{Name: name}}}
+}
+
+func concurrentTemplate(steps ...*pbc.StepTemplate) *pbc.StepTemplate {
+	return &pbc.StepTemplate{S: &pbc.StepTemplate_Concurrent{Concurrent: &pbc.StepConcurrentTemplate{Steps: steps}}}
+}
+
+// checkConnected fails the test unless every node in g can be reached
+// from the start node, and the end node can be reached from every node.
+func checkConnected(t *testing.T, g *Graph) {
+	t.Helper()
+
+	nodes := map[string]string{}
+	var collect func(c *graphCluster)
+	collect = func(c *graphCluster) {
+		for _, n := range c.nodes {
+			nodes[n.id] = n.label
+		}
+		for _, sub := range c.clusters {
+			collect(sub)
+		}
+	}
+	collect(g.root)
+
+	var start, end string
+	for id, label := range nodes {
+		switch label {
+		case "start":
+			start = id
+		case "end":
+			end = id
+		}
+	}
+
+	forward := map[string][]string{}
+	backward := map[string][]string{}
+	for _, e := range g.edges {
+		forward[e[0]] = append(forward[e[0]], e[1])
+		backward[e[1]] = append(backward[e[1]], e[0])
+	}
+	reach := func(from string, next map[string][]string) map[string]bool {
+		seen := map[string]bool{from: true}
+		todo := []string{from}
+		for len(todo) > 0 {
+			id := todo[0]
+			todo = todo[1:]
+			for _, to := range next[id] {
+				if !seen[to] {
+					seen[to] = true
+					todo = append(todo, to)
+				}
+			}
+		}
+		return seen
+	}
+
+	fromStart := reach(start, forward)
+	toEnd := reach(end, backward)
+	for id, label := range nodes {
+		if !fromStart[id] {
+			t.Errorf("node %s (%q) not reachable from start", id, label)
+		}
+		if !toEnd[id] {
+			t.Errorf("end not reachable from node %s (%q)", id, label)
+		}
+	}
+}
+
+func TestTemplateGraphConnected(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []*pbc.StepTemplate
+	}{
+		{"empty", nil},
+		{"sequence", []*pbc.StepTemplate{agentTemplate("a"), agentTemplate("b")}},
+		{"concurrent", []*pbc.StepTemplate{
+			agentTemplate("a"),
+			concurrentTemplate(agentTemplate("b"), agentTemplate("c")),
+			agentTemplate("d"),
+		}},
+		{"empty concurrent group", []*pbc.StepTemplate{
+			agentTemplate("a"),
+			concurrentTemplate(),
+			agentTemplate("b"),
+		}},
+		{"unknown step type", []*pbc.StepTemplate{
+			agentTemplate("a"),
+			{},
+			agentTemplate("b"),
+		}},
+		{"unknown step first", []*pbc.StepTemplate{
+			{},
+			agentTemplate("a"),
+		}},
+		{"unknown step in concurrent group", []*pbc.StepTemplate{
+			concurrentTemplate(agentTemplate("a"), &pbc.StepTemplate{}),
+		}},
+		{"expanded job set", []*pbc.StepTemplate{
+			jobSetTemplateStep("sub"),
+			agentTemplate("b"),
+		}},
+		{"empty job set", []*pbc.StepTemplate{
+			jobSetTemplateStep("empty"),
+			agentTemplate("b"),
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			templates := map[string]*pbc.JobSetTemplate{
+				"main":  {Name: "main", Steps: tc.steps},
+				"sub":   {Name: "sub", Steps: []*pbc.StepTemplate{agentTemplate("x"), agentTemplate("y")}},
+				"empty": {Name: "empty"},
+			}
+			g, err := TemplateGraph("main", templates)
+			if err != nil {
+				t.Fatalf("TemplateGraph: %v", err)
+			}
+			checkConnected(t, g)
+		})
+	}
+}
+
+func TestTemplateGraphNotFound(t *testing.T) {
+	if _, err := TemplateGraph("missing", map[string]*pbc.JobSetTemplate{}); err == nil {
+		t.Errorf("expected error for missing template")
+	}
+}
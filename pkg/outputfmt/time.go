@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package outputfmt
+
+import (
+	"fmt"
+	"time"
+)
+
+// Time formats supported by TimeOptions.
+const (
+	TimeRFC3339  = "rfc3339"
+	TimeRelative = "relative"
+	TimeUnix     = "unix"
+)
+
+// TimeOptions contains the settings for formatting times.
+type TimeOptions struct {
+	// Format is one of TimeRFC3339, TimeRelative or TimeUnix.
+	Format string
+	// UTC, if true, shows absolute times in UTC rather than local time.
+	UTC bool
+	// Now is the time that relative times and elapsed times for
+	// unfinished job sets are calculated from.
+	Now time.Time
+}
+
+// ValidateTimeFormat checks that format is a supported time format.
+func ValidateTimeFormat(format string) error {
+	switch format {
+	case TimeRFC3339, TimeRelative, TimeUnix:
+		return nil
+	}
+	return fmt.Errorf("unknown time format %s, expected %s, %s or %s", format, TimeRFC3339, TimeRelative, TimeUnix)
+}
+
+// FormatTime formats a time given in seconds since the Unix epoch. A
+// zero time means the time is not set, and is shown as "-".
+func FormatTime(unixTime int64, opts TimeOptions) string {
+	if unixTime == 0 {
+		return "-"
+	}
+
+	t := time.Unix(unixTime, 0)
+	switch opts.Format {
+	case TimeUnix:
+		return fmt.Sprintf("%d", unixTime)
+	case TimeRelative:
+		d := opts.Now.Sub(t).Round(time.Second)
+		if d < 0 {
+			return fmt.Sprintf("in %s", -d)
+		}
+		return fmt.Sprintf("%s ago", d)
+	}
+
+	if opts.UTC {
+		t = t.UTC()
+	}
+	return t.Format(time.RFC3339)
+}
+
+// FormatDuration formats how long something that started and finished
+// at the given Unix times ran for. If it has started but not finished,
+// the time elapsed so far is given, marked as such. If it has not
+// started, "-" is returned.
+func FormatDuration(started int64, finished int64, now time.Time) string {
+	d, ok := elapsed(started, finished, now)
+	if !ok {
+		return "-"
+	}
+	if finished == 0 {
+		return fmt.Sprintf("%s (so far)", d)
+	}
+	return d.String()
+}
+
+// elapsed returns how long something that started and finished at the
+// given Unix times ran for, or has been running so far if it has not
+// finished. It returns false if it has not started.
+func elapsed(started int64, finished int64, now time.Time) (time.Duration, bool) {
+	if started == 0 {
+		return 0, false
+	}
+	end := now
+	if finished != 0 {
+		end = time.Unix(finished, 0)
+	}
+	return end.Sub(time.Unix(started, 0)).Round(time.Second), true
+}
@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package outputfmt
+
+import (
+	"testing"
+	"time"
+)
+
+// timeTestNow is 2020-01-01T00:01:40Z.
+var timeTestNow = time.Unix(1577836900, 0)
+
+func TestValidateTimeFormat(t *testing.T) {
+	tests := []struct {
+		format  string
+		wantErr bool
+	}{
+		{TimeRFC3339, false},
+		{TimeRelative, false},
+		{TimeUnix, false},
+		{"", true},
+		{"RFC3339", true},
+		{"weekday", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.format, func(t *testing.T) {
+			err := ValidateTimeFormat(tc.format)
+			if tc.wantErr && err == nil {
+				t.Errorf("expected error")
+			} else if !tc.wantErr && err != nil {
+				t.Errorf("got error %v", err)
+			}
+		})
+	}
+}
+
+func TestFormatTime(t *testing.T) {
+	tests := []struct {
+		name string
+		unix int64
+		opts TimeOptions
+		want string
+	}{
+		{"not set", 0, TimeOptions{Format: TimeUnix}, "-"},
+		{"not set relative", 0, TimeOptions{Format: TimeRelative, Now: timeTestNow}, "-"},
+		{"unix", 1577836800, TimeOptions{Format: TimeUnix}, "1577836800"},
+		{"rfc3339 utc", 1577836800, TimeOptions{Format: TimeRFC3339, UTC: true}, "2020-01-01T00:00:00Z"},
+		{"unknown format is rfc3339", 1577836800, TimeOptions{UTC: true}, "2020-01-01T00:00:00Z"},
+		{"relative past", 1577836800, TimeOptions{Format: TimeRelative, Now: timeTestNow}, "1m40s ago"},
+		{"relative now", 1577836900, TimeOptions{Format: TimeRelative, Now: timeTestNow}, "0s ago"},
+		{"relative future", 1577837000, TimeOptions{Format: TimeRelative, Now: timeTestNow}, "in 1m40s"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := FormatTime(tc.unix, tc.opts); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFormatTimeLocal(t *testing.T) {
+	opts := TimeOptions{Format: TimeRFC3339}
+	want := time.Unix(1577836800, 0).Format(time.RFC3339)
+	if got := FormatTime(1577836800, opts); got != want {
+		t.Errorf("got %q, want local time %q", got, want)
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		started  int64
+		finished int64
+		want     string
+	}{
+		{"not started", 0, 0, "-"},
+		{"not started but finished", 0, 1577836850, "-"},
+		{"finished", 1577836800, 1577836850, "50s"},
+		{"finished instantly", 1577836800, 1577836800, "0s"},
+		{"running", 1577836800, 0, "1m40s (so far)"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := FormatDuration(tc.started, tc.finished, timeTestNow); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package outputfmt
+
+import (
+	"fmt"
+	"time"
+
+	pbc "github.com/swinslow/peridot-core/pkg/controller"
+	pbs "github.com/swinslow/peridot-core/pkg/status"
+)
+
+// TreeOptions contains the settings for drawing a job set as a tree.
+type TreeOptions struct {
+	// ExpandAll, if true, shows the sub-steps of concurrent steps that
+	// have finished successfully, which are otherwise collapsed.
+	ExpandAll bool
+	// Now is the time used to calculate elapsed time for job sets that
+	// are still running.
+	Now time.Time
+}
+
+// ConvertJobSetTree converts a job set into a slice of string lines
+// drawing its step hierarchy as a tree, with a glyph for each step's
+// status. Sub-job sets started by jobset steps are looked up using
+// getJobSet, if it is non-nil, and shown with their own steps and
+// elapsed time. The controller does not report times for individual
+// agent steps, so only job sets show elapsed time.
+func ConvertJobSetTree(jsd *pbc.JobSetDetails, getJobSet func(id uint64) (*pbc.JobSetDetails, error), opts TreeOptions) []string {
+	glyph := "?"
+	if jsd.St != nil {
+		glyph = statusGlyph(jsd.St.RunStatus, jsd.St.HealthStatus)
+	}
+	root := fmt.Sprintf("%s job set %d: %s", glyph, jsd.JobSetID, jsd.TemplateName)
+	if e := jobSetElapsed(jsd, opts.Now); e != "" {
+		root += " " + e
+	}
+
+	t := &treeWriter{getJobSet: getJobSet, opts: opts, expanding: map[uint64]bool{jsd.JobSetID: true}}
+	t.lines = []string{root}
+	t.addSteps(jsd.Steps, "")
+	return t.lines
+}
+
+type treeWriter struct {
+	getJobSet func(id uint64) (*pbc.JobSetDetails, error)
+	opts      TreeOptions
+	expanding map[uint64]bool
+	lines     []string
+}
+
+func (t *treeWriter) addSteps(steps []*pbc.Step, prefix string) {
+	for i, step := range steps {
+		branch, childPrefix := "├── ", prefix+"│   "
+		if i == len(steps)-1 {
+			branch, childPrefix = "└── ", prefix+"    "
+		}
+		line := prefix + branch + statusGlyph(step.RunStatus, step.HealthStatus) + " "
+
+		switch x := step.S.(type) {
+		case *pbc.Step_Agent:
+			t.lines = append(t.lines, line+fmt.Sprintf("agent %s (job %d)", x.Agent.AgentName, x.Agent.JobID))
+
+		case *pbc.Step_Jobset:
+			line += fmt.Sprintf("jobset %s (job set %d)", x.Jobset.TemplateName, x.Jobset.JobSetID)
+			var sub *pbc.JobSetDetails
+			if t.getJobSet != nil && !t.expanding[x.Jobset.JobSetID] {
+				sub, _ = t.getJobSet(x.Jobset.JobSetID)
+			}
+			if sub == nil {
+				t.lines = append(t.lines, line)
+				continue
+			}
+			if e := jobSetElapsed(sub, t.opts.Now); e != "" {
+				line += " " + e
+			}
+			t.lines = append(t.lines, line)
+			t.expanding[x.Jobset.JobSetID] = true
+			t.addSteps(sub.Steps, childPrefix)
+			delete(t.expanding, x.Jobset.JobSetID)
+
+		case *pbc.Step_Concurrent:
+			n := len(x.Concurrent.Steps)
+			if !t.opts.ExpandAll && isFinishedOK(step.RunStatus, step.HealthStatus) {
+				t.lines = append(t.lines, line+fmt.Sprintf("concurrent (%d steps, collapsed)", n))
+				continue
+			}
+			t.lines = append(t.lines, line+"concurrent")
+			t.addSteps(x.Concurrent.Steps, childPrefix)
+		}
+	}
+}
+
+func isFinishedOK(runStatus pbs.Status, health pbs.Health) bool {
+	return runStatus == pbs.Status_STOPPED && health == pbs.Health_OK
+}
+
+// statusGlyph returns a single character showing a step's status.
+func statusGlyph(runStatus pbs.Status, health pbs.Health) string {
+	switch health {
+	case pbs.Health_ERROR:
+		return "✘"
+	case pbs.Health_DEGRADED:
+		return "!"
+	}
+	switch runStatus {
+	case pbs.Status_STARTUP:
+		return "○"
+	case pbs.Status_RUNNING:
+		return "▶"
+	case pbs.Status_STOPPED:
+		return "✔"
+	}
+	return "?"
+}
+
+// jobSetElapsed returns how long a job set ran for, or has been running
+// so far if it has not finished, or "" if it has not started.
+func jobSetElapsed(jsd *pbc.JobSetDetails, now time.Time) string {
+	if jsd.St == nil {
+		return ""
+	}
+	d, ok := elapsed(jsd.St.TimeStarted, jsd.St.TimeFinished, now)
+	if !ok {
+		return ""
+	}
+	return d.String()
+}
@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package outputfmt
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	pbc "github.com/swinslow/peridot-core/pkg/controller"
+	pbs "github.com/swinslow/peridot-core/pkg/status"
+)
+
+func agentStep(name string, jobID uint64, run pbs.Status, health pbs.Health) *pbc.Step {
+	return &pbc.Step{
+		RunStatus:    run,
+		HealthStatus: health,
+		S:            &pbc.Step_Agent{Agent: &pbc.StepAgent{AgentName: name, JobID: jobID}},
+	}
+}
+
+func jobSetStep(name string, jobSetID uint64, run pbs.Status, health pbs.Health) *pbc.Step {
+	return &pbc.Step{
+		RunStatus:    run,
+		HealthStatus: health,
+		S:            &pbc.Step_Jobset{Jobset: &pbc.StepJobSet{TemplateName: name, JobSetID: jobSetID}},
+	}
+}
+
+func concurrentStep(run pbs.Status, health pbs.Health, steps ...*pbc.Step) *pbc.Step {
+	return &pbc.Step{
+		RunStatus:    run,
+		HealthStatus: health,
+		S:            &pbc.Step_Concurrent{Concurrent: &pbc.StepConcurrent{Steps: steps}},
+	}
+}
+
+// treeTestJobSets returns job set 1, which is running, and a getter for
+// it and its sub-job set 2, which has finished.
+func treeTestJobSets() (*pbc.JobSetDetails, func(id uint64) (*pbc.JobSetDetails, error)) {
+	jobSets := map[uint64]*pbc.JobSetDetails{
+		1: {
+			JobSetID:     1,
+			TemplateName: "full",
+			St:           &pbc.StatusReport{RunStatus: pbs.Status_RUNNING, HealthStatus: pbs.Health_OK, TimeStarted: 1577836800},
+			Steps: []*pbc.Step{
+				concurrentStep(pbs.Status_STOPPED, pbs.Health_OK,
+					agentStep("idsearcher", 1, pbs.Status_STOPPED, pbs.Health_OK),
+					agentStep("tagger", 2, pbs.Status_STOPPED, pbs.Health_OK)),
+				jobSetStep("scan", 2, pbs.Status_STOPPED, pbs.Health_DEGRADED),
+				agentStep("flaky", 4, pbs.Status_RUNNING, pbs.Health_OK),
+				jobSetStep("full", 1, pbs.Status_STARTUP, pbs.Health_OK),
+			},
+		},
+		2: {
+			JobSetID:     2,
+			TemplateName: "scan",
+			St:           &pbc.StatusReport{RunStatus: pbs.Status_STOPPED, HealthStatus: pbs.Health_DEGRADED, TimeStarted: 1577836810, TimeFinished: 1577836850},
+			Steps: []*pbc.Step{
+				agentStep("idsearcher", 3, pbs.Status_STOPPED, pbs.Health_ERROR),
+			},
+		},
+	}
+
+	return jobSets[1], func(id uint64) (*pbc.JobSetDetails, error) {
+		jsd, ok := jobSets[id]
+		if !ok {
+			return nil, fmt.Errorf("job set %d not found", id)
+		}
+		return jsd, nil
+	}
+}
+
+func TestConvertJobSetTree(t *testing.T) {
+	jsd, getJobSet := treeTestJobSets()
+
+	tests := []struct {
+		name      string
+		getJobSet func(id uint64) (*pbc.JobSetDetails, error)
+		expandAll bool
+		want      []string
+	}{
+		{"collapsed", getJobSet, false, []string{
+			"▶ job set 1: full 1m40s",
+			"├── ✔ concurrent (2 steps, collapsed)",
+			"├── ! jobset scan (job set 2) 40s",
+			"│   └── ✘ agent idsearcher (job 3)",
+			"├── ▶ agent flaky (job 4)",
+			"└── ○ jobset full (job set 1)",
+		}},
+		{"expand all", getJobSet, true, []string{
+			"▶ job set 1: full 1m40s",
+			"├── ✔ concurrent",
+			"│   ├── ✔ agent idsearcher (job 1)",
+			"│   └── ✔ agent tagger (job 2)",
+			"├── ! jobset scan (job set 2) 40s",
+			"│   └── ✘ agent idsearcher (job 3)",
+			"├── ▶ agent flaky (job 4)",
+			"└── ○ jobset full (job set 1)",
+		}},
+		{"no getter", nil, false, []string{
+			"▶ job set 1: full 1m40s",
+			"├── ✔ concurrent (2 steps, collapsed)",
+			"├── ! jobset scan (job set 2)",
+			"├── ▶ agent flaky (job 4)",
+			"└── ○ jobset full (job set 1)",
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := ConvertJobSetTree(jsd, tc.getJobSet, TreeOptions{ExpandAll: tc.expandAll, Now: timeTestNow})
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestConvertJobSetTreeNoStatus(t *testing.T) {
+	jsd := &pbc.JobSetDetails{
+		JobSetID:     5,
+		TemplateName: "pending",
+		Steps: []*pbc.Step{
+			{S: &pbc.Step_Jobset{Jobset: &pbc.StepJobSet{TemplateName: "scan", JobSetID: 6}}},
+		},
+	}
+	getJobSet := func(id uint64) (*pbc.JobSetDetails, error) {
+		return &pbc.JobSetDetails{JobSetID: id, TemplateName: "scan"}, nil
+	}
+
+	got := ConvertJobSetTree(jsd, getJobSet, TreeOptions{Now: timeTestNow})
+	want := []string{
+		"? job set 5: pending",
+		"└── ? jobset scan (job set 6)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestStatusGlyph(t *testing.T) {
+	tests := []struct {
+		run    pbs.Status
+		health pbs.Health
+		want   string
+	}{
+		{pbs.Status_STARTUP, pbs.Health_OK, "○"},
+		{pbs.Status_RUNNING, pbs.Health_OK, "▶"},
+		{pbs.Status_STOPPED, pbs.Health_OK, "✔"},
+		{pbs.Status_RUNNING, pbs.Health_DEGRADED, "!"},
+		{pbs.Status_STOPPED, pbs.Health_ERROR, "✘"},
+		{pbs.Status(99), pbs.Health_OK, "?"},
+	}
+
+	for _, tc := range tests {
+		if got := statusGlyph(tc.run, tc.health); got != tc.want {
+			t.Errorf("statusGlyph(%s, %s): got %s, want %s", tc.run, tc.health, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// File formats that requests can be written in.
+const (
+	FormatYAML = "YAML"
+	FormatJSON = "JSON"
+	FormatTOML = "TOML"
+)
+
+// tomlLinePattern matches the start of a TOML table header or key/value
+// pair, which cannot start a YAML mapping.
+var tomlLinePattern = regexp.MustCompile(`^(\[|[A-Za-z0-9_."'-]+\s*=)`)
+
+// DetectFormat determines which format a file is written in, first by
+// its extension and otherwise by looking at its contents.
+func DetectFormat(filePath string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".json":
+		return FormatJSON
+	case ".toml":
+		return FormatTOML
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return FormatJSON
+	}
+
+	// look at the first line that isn't blank or a comment
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if tomlLinePattern.MatchString(line) {
+			return FormatTOML
+		}
+		break
+	}
+
+	return FormatYAML
+}
+
+// decodeDocument decodes data in the given format into a generic YAML
+// document, so that it can be processed like any other request. JSON
+// is already valid YAML, and TOML is converted to YAML first.
+func decodeDocument(format string, data []byte) (yaml.MapSlice, error) {
+	switch format {
+	case FormatJSON:
+		if !json.Valid(data) {
+			var v interface{}
+			return nil, fmt.Errorf("invalid JSON: %v", json.Unmarshal(data, &v))
+		}
+
+	case FormatTOML:
+		v := map[string]interface{}{}
+		if _, err := toml.Decode(string(data), &v); err != nil {
+			return nil, fmt.Errorf("invalid TOML: %v", err)
+		}
+		var err error
+		data, err = yaml.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	doc := yaml.MapSlice{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// encodeDocument encodes a generic YAML document in the given format,
+// keeping the order of keys except in TOML.
+func encodeDocument(format string, doc yaml.MapSlice) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		buf := &bytes.Buffer{}
+		if err := writeJSON(buf, doc); err != nil {
+			return nil, err
+		}
+		out := &bytes.Buffer{}
+		if err := json.Indent(out, buf.Bytes(), "", "  "); err != nil {
+			return nil, err
+		}
+		out.WriteString("\n")
+		return out.Bytes(), nil
+
+	case FormatTOML:
+		buf := &bytes.Buffer{}
+		if err := toml.NewEncoder(buf).Encode(toMap(doc)); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	return yaml.Marshal(doc)
+}
+
+// writeJSON writes v, a value from a generic YAML document, as JSON,
+// keeping the order of keys in mappings.
+func writeJSON(buf *bytes.Buffer, v interface{}) error {
+	switch x := v.(type) {
+	case yaml.MapSlice:
+		buf.WriteString("{")
+		for i, item := range x {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			key, err := json.Marshal(fmt.Sprint(item.Key))
+			if err != nil {
+				return err
+			}
+			buf.Write(key)
+			buf.WriteString(":")
+			if err := writeJSON(buf, item.Value); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("}")
+		return nil
+
+	case []interface{}:
+		buf.WriteString("[")
+		for i, val := range x {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			if err := writeJSON(buf, val); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("]")
+		return nil
+	}
+
+	out, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	buf.Write(out)
+	return nil
+}
+
+// toMap converts v, a value from a generic YAML document, into plain
+// maps and slices for encoding as TOML.
+func toMap(v interface{}) interface{} {
+	switch x := v.(type) {
+	case yaml.MapSlice:
+		m := map[string]interface{}{}
+		for _, item := range x {
+			m[fmt.Sprint(item.Key)] = toMap(item.Value)
+		}
+		return m
+
+	case []interface{}:
+		// TOML needs arrays of tables to have a table type
+		tables := []map[string]interface{}{}
+		values := []interface{}{}
+		for _, val := range x {
+			conv := toMap(val)
+			if m, ok := conv.(map[string]interface{}); ok {
+				tables = append(tables, m)
+			}
+			values = append(values, conv)
+		}
+		if len(x) > 0 && len(tables) == len(x) {
+			return tables
+		}
+		return values
+	}
+
+	return v
+}
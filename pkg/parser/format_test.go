@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+const formatTestYAML = `apiVersion: v0-alpha1
+agents:
+- name: idsearcher
+  url: localhost
+  port: 9001
+  type: idsearcher
+  configs:
+    mode: ${MODE:-fast}
+jobSetTemplates:
+- name: scan
+  steps:
+  - type: agent
+    name: idsearcher
+  - type: concurrent
+    steps:
+    - type: agent
+      name: idsearcher
+`
+
+const formatTestJSON = `{
+  "apiVersion": "v0-alpha1",
+  "agents": [{"name": "idsearcher", "url": "localhost", "port": 9001, "type": "idsearcher"}]
+}`
+
+const formatTestTOML = `apiVersion = "v0-alpha1"
+
+[[agents]]
+name = "idsearcher"
+url = "localhost"
+port = 9001
+type = "idsearcher"
+`
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		data string
+		want string
+	}{
+		{"yaml extension", "m.yaml", formatTestJSON, FormatYAML},
+		{"yml extension", "m.YML", "", FormatYAML},
+		{"json extension", "m.json", formatTestYAML, FormatJSON},
+		{"toml extension", "m.toml", "", FormatTOML},
+		{"json contents", "m", "  " + formatTestJSON, FormatJSON},
+		{"toml contents", "m", "# comment\n\n" + formatTestTOML, FormatTOML},
+		{"toml table first", "m", "[[agents]]\nname = \"x\"\n", FormatTOML},
+		{"yaml contents", "m", formatTestYAML, FormatYAML},
+		{"empty", "m", "", FormatYAML},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := DetectFormat(tc.path, []byte(tc.data)); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecodeDocumentFormatsAgree(t *testing.T) {
+	want, err := decodeDocument(FormatJSON, []byte(formatTestJSON))
+	if err != nil {
+		t.Fatalf("JSON: %v", err)
+	}
+	got, err := decodeDocument(FormatTOML, []byte(formatTestTOML))
+	if err != nil {
+		t.Fatalf("TOML: %v", err)
+	}
+
+	// TOML doesn't keep the order of keys, so compare as plain maps
+	if !reflect.DeepEqual(toMap(got), toMap(want)) {
+		t.Errorf("TOML decoded as %#v, want %#v", toMap(got), toMap(want))
+	}
+}
+
+func TestDecodeDocumentErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		data   string
+	}{
+		{"bad JSON", FormatJSON, `{"apiVersion": }`},
+		{"bad TOML", FormatTOML, `apiVersion = `},
+		{"bad YAML", FormatYAML, "apiVersion: [v0-alpha1\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := decodeDocument(tc.format, []byte(tc.data)); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
+
+func TestEncodeDocumentRoundTrip(t *testing.T) {
+	doc, err := decodeDocument(FormatYAML, []byte(formatTestYAML))
+	if err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+
+	for _, format := range []string{FormatYAML, FormatJSON, FormatTOML} {
+		t.Run(format, func(t *testing.T) {
+			out, err := encodeDocument(format, doc)
+			if err != nil {
+				t.Fatalf("encoding: %v", err)
+			}
+			if got := DetectFormat("m", out); got != format {
+				t.Errorf("encoded data detected as %s:\n%s", got, out)
+			}
+
+			back, err := decodeDocument(format, out)
+			if err != nil {
+				t.Fatalf("decoding encoded data: %v\n%s", err, out)
+			}
+			if format != FormatTOML && !reflect.DeepEqual(back, doc) {
+				t.Errorf("round trip changed document:\n%s", out)
+			}
+			if !reflect.DeepEqual(toMap(back), toMap(doc)) {
+				t.Errorf("round trip changed values:\n%s", out)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package parser
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// includeLoader loads a YAML file together with any files it includes,
+// keeping track of which files have been loaded so that each is only
+// included once and cycles can be detected.
+type includeLoader struct {
+	opts    ParseOptions
+	loaded  map[string]bool
+	loading []string
+}
+
+// load reads and parses filePath, then recursively loads each of its
+// included files and adds their agents, templates and step groups
+// ahead of its own.
+func (l *includeLoader) load(filePath string) (*PeridotReq, error) {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range l.loading {
+		if p == absPath {
+			cycle := append(append([]string{}, l.loading[i:]...), absPath)
+			return nil, fmt.Errorf("include cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	req := PeridotReq{}
+	err = readFile(filePath, l.opts, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	l.loaded[absPath] = true
+	l.loading = append(l.loading, absPath)
+	defer func() { l.loading = l.loading[:len(l.loading)-1] }()
+
+	merged := PeridotReq{
+		APIVersion: req.APIVersion,
+		Overlays:   req.Overlays,
+	}
+	for _, inc := range req.Include {
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(filepath.Dir(filePath), inc)
+		}
+		incPath, err := filepath.Abs(inc)
+		if err != nil {
+			return nil, err
+		}
+		if l.loaded[incPath] && !l.isLoading(incPath) {
+			// already included elsewhere
+			continue
+		}
+
+		incReq, err := l.load(inc)
+		if err != nil {
+			return nil, err
+		}
+		if len(incReq.Overlays) > 0 {
+			return nil, fmt.Errorf("included file %s: overlays are only allowed in the top-level file", inc)
+		}
+
+		merged.Agents = append(merged.Agents, incReq.Agents...)
+		merged.Templates = append(merged.Templates, incReq.Templates...)
+		merged.StepGroups = append(merged.StepGroups, incReq.StepGroups...)
+	}
+
+	merged.Agents = append(merged.Agents, req.Agents...)
+	merged.Templates = append(merged.Templates, req.Templates...)
+	merged.StepGroups = append(merged.StepGroups, req.StepGroups...)
+
+	return &merged, nil
+}
+
+func (l *includeLoader) isLoading(absPath string) bool {
+	for _, p := range l.loading {
+		if p == absPath {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpandStepGroups replaces each "group" step in the request's
+// JobSetTemplates with the steps of the step group it names, including
+// within concurrent sub-steps and other step groups. The request's step
+// groups are then removed. It returns an error if a group is unknown,
+// defined more than once or refers to itself.
+func ExpandStepGroups(req *PeridotReq) error {
+	groups := map[string][]PeridotJSTStep{}
+	for _, g := range req.StepGroups {
+		if g.Name == "" {
+			return fmt.Errorf("got step group with no name, expected name")
+		}
+		if _, ok := groups[g.Name]; ok {
+			return fmt.Errorf("got more than one step group named %s", g.Name)
+		}
+		groups[g.Name] = g.Steps
+	}
+
+	for i := range req.Templates {
+		steps, err := expandSteps(req.Templates[i].Steps, groups, nil)
+		if err != nil {
+			return fmt.Errorf("template %s: %v", req.Templates[i].Name, err)
+		}
+		req.Templates[i].Steps = steps
+	}
+
+	req.StepGroups = nil
+	return nil
+}
+
+func expandSteps(steps []PeridotJSTStep, groups map[string][]PeridotJSTStep, expanding []string) ([]PeridotJSTStep, error) {
+	expanded := []PeridotJSTStep{}
+
+	for _, step := range steps {
+		switch step.TypeStr {
+		case "group":
+			groupSteps, ok := groups[step.Name]
+			if !ok {
+				return nil, fmt.Errorf("unknown step group %s", step.Name)
+			}
+			for i, g := range expanding {
+				if g == step.Name {
+					cycle := append(append([]string{}, expanding[i:]...), step.Name)
+					return nil, fmt.Errorf("step group cycle: %s", strings.Join(cycle, " -> "))
+				}
+			}
+			subSteps, err := expandSteps(groupSteps, groups, append(expanding, step.Name))
+			if err != nil {
+				return nil, err
+			}
+			expanded = append(expanded, subSteps...)
+
+		case "concurrent":
+			subSteps, err := expandSteps(step.Steps, groups, expanding)
+			if err != nil {
+				return nil, err
+			}
+			step.Steps = subSteps
+			expanded = append(expanded, step)
+
+		default:
+			expanded = append(expanded, step)
+		}
+	}
+
+	return expanded, nil
+}
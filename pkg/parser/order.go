@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OrderJobSetTemplates sorts the requested JobSetTemplates so that any
+// template referenced by another template's "jobset" step (including
+// within concurrent sub-steps) comes before the template that uses it.
+// Templates that do not depend on one another keep their file order.
+// References to templates not defined in the request are assumed to be
+// already registered with the controller and are ignored. It returns an
+// error if the templates contain a reference cycle.
+func OrderJobSetTemplates(templates []PeridotJobSetTemplate) ([]PeridotJobSetTemplate, error) {
+	byName := map[string]int{}
+	for i, template := range templates {
+		byName[template.Name] = i
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(templates))
+	ordered := []PeridotJobSetTemplate{}
+	path := []string{}
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visited:
+			return nil
+		case visiting:
+			// find where the cycle starts in the current path
+			name := templates[i].Name
+			start := 0
+			for j, p := range path {
+				if p == name {
+					start = j
+					break
+				}
+			}
+			cycle := append(append([]string{}, path[start:]...), name)
+			return fmt.Errorf("job set template reference cycle: %s", strings.Join(cycle, " -> "))
+		}
+
+		state[i] = visiting
+		path = append(path, templates[i].Name)
+		for _, dep := range jobSetStepNames(templates[i].Steps) {
+			j, ok := byName[dep]
+			if !ok {
+				continue
+			}
+			if err := visit(j); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		ordered = append(ordered, templates[i])
+		return nil
+	}
+
+	for i := range templates {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+
+	return ordered, nil
+}
+
+// jobSetStepNames returns the names of all templates referenced by
+// "jobset" steps, recursing into concurrent sub-steps, in the order
+// they appear.
+func jobSetStepNames(steps []PeridotJSTStep) []string {
+	names := []string{}
+	for _, step := range steps {
+		switch step.TypeStr {
+		case "jobset":
+			names = append(names, step.Name)
+		case "concurrent":
+			names = append(names, jobSetStepNames(step.Steps)...)
+		}
+	}
+	return names
+}
@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func agentStep(name string) PeridotJSTStep {
+	return PeridotJSTStep{TypeStr: "agent", Name: name}
+}
+
+func jobSetStep(name string) PeridotJSTStep {
+	return PeridotJSTStep{TypeStr: "jobset", Name: name}
+}
+
+func concurrentStep(steps ...PeridotJSTStep) PeridotJSTStep {
+	return PeridotJSTStep{TypeStr: "concurrent", Steps: steps}
+}
+
+func template(name string, steps ...PeridotJSTStep) PeridotJobSetTemplate {
+	return PeridotJobSetTemplate{Name: name, Steps: steps}
+}
+
+func templateNames(templates []PeridotJobSetTemplate) []string {
+	names := []string{}
+	for _, t := range templates {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+func TestOrderJobSetTemplates(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates []PeridotJobSetTemplate
+		want      []string
+	}{
+		{"none", nil, []string{}},
+		{"independent keep order", []PeridotJobSetTemplate{
+			template("b", agentStep("x")),
+			template("a", agentStep("y")),
+		}, []string{"b", "a"}},
+		{"dependency first", []PeridotJobSetTemplate{
+			template("full", jobSetStep("scan")),
+			template("scan", agentStep("x")),
+		}, []string{"scan", "full"}},
+		{"dependency in concurrent step", []PeridotJobSetTemplate{
+			template("full", concurrentStep(agentStep("x"), jobSetStep("scan"))),
+			template("scan", agentStep("x")),
+		}, []string{"scan", "full"}},
+		{"chain", []PeridotJobSetTemplate{
+			template("a", jobSetStep("b")),
+			template("b", jobSetStep("c")),
+			template("c", agentStep("x")),
+		}, []string{"c", "b", "a"}},
+		{"shared dependency once", []PeridotJobSetTemplate{
+			template("a", jobSetStep("c")),
+			template("b", jobSetStep("c")),
+			template("c", agentStep("x")),
+		}, []string{"c", "a", "b"}},
+		{"unknown reference ignored", []PeridotJobSetTemplate{
+			template("a", jobSetStep("registered")),
+		}, []string{"a"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := OrderJobSetTemplates(tc.templates)
+			if err != nil {
+				t.Fatalf("OrderJobSetTemplates: %v", err)
+			}
+			if names := templateNames(got); !reflect.DeepEqual(names, tc.want) {
+				t.Errorf("got order %v, want %v", names, tc.want)
+			}
+		})
+	}
+}
+
+func TestOrderJobSetTemplatesCycle(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates []PeridotJobSetTemplate
+		want      string
+	}{
+		{"self", []PeridotJobSetTemplate{
+			template("a", jobSetStep("a")),
+		}, "a -> a"},
+		{"two", []PeridotJobSetTemplate{
+			template("a", jobSetStep("b")),
+			template("b", concurrentStep(jobSetStep("a"))),
+		}, "a -> b -> a"},
+		{"after a prefix", []PeridotJobSetTemplate{
+			template("a", jobSetStep("b")),
+			template("b", jobSetStep("c")),
+			template("c", jobSetStep("b")),
+		}, "b -> c -> b"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := OrderJobSetTemplates(tc.templates)
+			if err == nil || !strings.HasSuffix(err.Error(), tc.want) {
+				t.Errorf("got error %v, want cycle %s", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package parser
+
+import "fmt"
+
+// PeridotOverlay represents the parsed YAML data for an overlay, which
+// patches the agents and JobSetTemplates of a base request by name.
+type PeridotOverlay struct {
+	APIVersion string                       `yaml:"apiVersion"`
+	Agents     []PeridotAgent               `yaml:",omitempty"`
+	Templates  []PeridotJobSetTemplatePatch `yaml:"jobSetTemplates,omitempty"`
+}
+
+// PeridotJobSetTemplatePatch represents the parsed YAML data for a
+// patch to a peridotctl JobSetTemplate object. If Steps is non-empty,
+// it replaces the template's steps; InsertSteps are then applied in
+// order.
+type PeridotJobSetTemplatePatch struct {
+	Name        string
+	Steps       []PeridotJSTStep    `yaml:",omitempty"`
+	InsertSteps []PeridotStepInsert `yaml:"insertSteps,omitempty"`
+}
+
+// PeridotStepInsert represents the parsed YAML data for steps to be
+// inserted into a JobSetTemplate by an overlay. At is the zero-based
+// index of the top-level step before which to insert; if omitted, the
+// steps are appended at the end.
+type PeridotStepInsert struct {
+	At    *int `yaml:",omitempty"`
+	Steps []PeridotJSTStep
+}
+
+// MergeOverlay applies an overlay to the request. Agents and templates
+// are matched by name; ones that are not already present in the request
+// are added to it.
+//
+// For a matching agent, any non-empty URL, port and type in the overlay
+// replace the existing values, and configs are merged key by key.
+func MergeOverlay(req *PeridotReq, overlay *PeridotOverlay) error {
+	for _, oa := range overlay.Agents {
+		if oa.Name == "" {
+			return fmt.Errorf("got overlay agent with no name, expected name")
+		}
+		mergeAgent(req, oa)
+	}
+
+	for _, ot := range overlay.Templates {
+		if ot.Name == "" {
+			return fmt.Errorf("got overlay template with no name, expected name")
+		}
+		if err := mergeTemplate(req, ot); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func mergeAgent(req *PeridotReq, oa PeridotAgent) {
+	for i := range req.Agents {
+		agent := &req.Agents[i]
+		if agent.Name != oa.Name {
+			continue
+		}
+
+		if oa.URL != "" {
+			agent.URL = oa.URL
+		}
+		if oa.Port != 0 {
+			agent.Port = oa.Port
+		}
+		if oa.TypeStr != "" {
+			agent.TypeStr = oa.TypeStr
+		}
+		if len(oa.Configs) > 0 && agent.Configs == nil {
+			agent.Configs = map[string]string{}
+		}
+		for k, v := range oa.Configs {
+			agent.Configs[k] = v
+		}
+		return
+	}
+
+	// not found, so add it as a new agent
+	req.Agents = append(req.Agents, oa)
+}
+
+func mergeTemplate(req *PeridotReq, ot PeridotJobSetTemplatePatch) error {
+	var template *PeridotJobSetTemplate
+	for i := range req.Templates {
+		if req.Templates[i].Name == ot.Name {
+			template = &req.Templates[i]
+			break
+		}
+	}
+	if template == nil {
+		// not found, so add it as a new template
+		req.Templates = append(req.Templates, PeridotJobSetTemplate{Name: ot.Name})
+		template = &req.Templates[len(req.Templates)-1]
+	}
+
+	if len(ot.Steps) > 0 {
+		template.Steps = ot.Steps
+	}
+
+	for _, ins := range ot.InsertSteps {
+		at := len(template.Steps)
+		if ins.At != nil {
+			at = *ins.At
+		}
+		if at < 0 || at > len(template.Steps) {
+			return fmt.Errorf("invalid insertSteps position %d for template %s with %d steps", at, ot.Name, len(template.Steps))
+		}
+
+		steps := append([]PeridotJSTStep{}, template.Steps[:at]...)
+		steps = append(steps, ins.Steps...)
+		template.Steps = append(steps, template.Steps[at:]...)
+	}
+
+	return nil
+}
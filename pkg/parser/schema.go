@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package parser
+
+// SchemaJSON is a JSON Schema (draft-07) describing the YAML format for
+// a PeridotReq, for any supported apiVersion. It mirrors the checks in
+// ValidateReq, so that editors can offer completion and inline
+// validation for manifests. It must be kept in sync with types.go and
+// validate.go.
+const SchemaJSON = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "https://github.com/swinslow/peridotctl/schema/v0-alpha1.json",
+  "title": "peridotctl manifest",
+  "description": "Agents and job set templates to apply to a peridot controller.",
+  "type": "object",
+  "required": ["apiVersion"],
+  "additionalProperties": false,
+  "properties": {
+    "apiVersion": {
+      "description": "Manifest format version.",
+      "const": "v0-alpha1"
+    },
+    "include": {
+      "description": "Other manifest files to include, relative to this file.",
+      "type": "array",
+      "items": {"type": "string"}
+    },
+    "overlays": {
+      "description": "Overlay files that patch agents and templates by name, relative to this file.",
+      "type": "array",
+      "items": {"type": "string"}
+    },
+    "agents": {
+      "type": "array",
+      "items": {"$ref": "#/definitions/agent"}
+    },
+    "jobSetTemplates": {
+      "type": "array",
+      "items": {"$ref": "#/definitions/jobSetTemplate"}
+    },
+    "stepGroups": {
+      "description": "Reusable sequences of steps, used by steps of type group.",
+      "type": "array",
+      "items": {"$ref": "#/definitions/stepGroup"}
+    }
+  },
+  "definitions": {
+    "agent": {
+      "type": "object",
+      "required": ["name", "url", "port", "type"],
+      "additionalProperties": false,
+      "properties": {
+        "name": {"description": "Unique name for agent instance.", "type": "string", "minLength": 1},
+        "url": {"description": "Agent instance hostname (omitting port).", "type": "string", "minLength": 1},
+        "port": {
+          "description": "Agent instance port, or a ${VAR} reference to one.",
+          "oneOf": [
+            {"type": "integer", "minimum": 1, "maximum": 4294967295},
+            {"type": "string", "pattern": "^\\$\\{[A-Za-z_][A-Za-z0-9_]*(:-[1-9][0-9]*)?\\}$"}
+          ]
+        },
+        "type": {"description": "Agent instance type.", "type": "string", "minLength": 1},
+        "configs": {
+          "description": "Agent configuration key-value pairs.",
+          "type": "object",
+          "additionalProperties": {"type": "string"}
+        }
+      }
+    },
+    "jobSetTemplate": {
+      "type": "object",
+      "required": ["name", "steps"],
+      "additionalProperties": false,
+      "properties": {
+        "name": {"type": "string", "minLength": 1},
+        "steps": {"$ref": "#/definitions/steps"}
+      }
+    },
+    "stepGroup": {
+      "type": "object",
+      "required": ["name", "steps"],
+      "additionalProperties": false,
+      "properties": {
+        "name": {"type": "string", "minLength": 1},
+        "steps": {"$ref": "#/definitions/steps"}
+      }
+    },
+    "steps": {
+      "type": "array",
+      "minItems": 1,
+      "items": {"$ref": "#/definitions/step"}
+    },
+    "step": {
+      "type": "object",
+      "required": ["type"],
+      "additionalProperties": false,
+      "properties": {
+        "type": {"enum": ["agent", "jobset", "concurrent", "group"]},
+        "name": {"type": "string"},
+        "steps": {"$ref": "#/definitions/steps"}
+      },
+      "if": {
+        "properties": {"type": {"const": "concurrent"}}
+      },
+      "then": {
+        "description": "A concurrent step runs its sub-steps at the same time.",
+        "required": ["steps"],
+        "not": {"required": ["name"]}
+      },
+      "else": {
+        "description": "An agent, jobset or group step names what it runs.",
+        "required": ["name"],
+        "properties": {"name": {"minLength": 1}},
+        "not": {"required": ["steps"]}
+      }
+    }
+  }
+}
+`
@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package parser
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// schemaTestObject is the part of a JSON Schema object that the tests
+// compare against the parser's types.
+type schemaTestObject struct {
+	Properties map[string]json.RawMessage `json:"properties"`
+}
+
+// yamlKeys returns the sorted keys that yaml.v2 uses for the fields of
+// the struct type t.
+func yamlKeys(t reflect.Type) []string {
+	keys := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if key == "" {
+			key = strings.ToLower(f.Name)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func propertyKeys(obj schemaTestObject) []string {
+	keys := []string{}
+	for k := range obj.Properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestSchemaMatchesTypes(t *testing.T) {
+	if !json.Valid([]byte(SchemaJSON)) {
+		t.Fatal("SchemaJSON is not valid JSON")
+	}
+
+	var schema struct {
+		schemaTestObject
+		Definitions map[string]schemaTestObject `json:"definitions"`
+	}
+	if err := json.Unmarshal([]byte(SchemaJSON), &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		obj  schemaTestObject
+		typ  interface{}
+	}{
+		{"top level", schema.schemaTestObject, PeridotReq{}},
+		{"agent", schema.Definitions["agent"], PeridotAgent{}},
+		{"jobSetTemplate", schema.Definitions["jobSetTemplate"], PeridotJobSetTemplate{}},
+		{"stepGroup", schema.Definitions["stepGroup"], PeridotStepGroup{}},
+		{"step", schema.Definitions["step"], PeridotJSTStep{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := propertyKeys(tc.obj)
+			want := yamlKeys(reflect.TypeOf(tc.typ))
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got schema properties %v, want %v to match %T", got, want, tc.typ)
+			}
+		})
+	}
+}
+
+func TestSchemaPortPattern(t *testing.T) {
+	var schema struct {
+		Definitions map[string]struct {
+			Properties map[string]struct {
+				OneOf []struct {
+					Type    string `json:"type"`
+					Pattern string `json:"pattern"`
+				} `json:"oneOf"`
+			} `json:"properties"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal([]byte(SchemaJSON), &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	pattern := ""
+	for _, alt := range schema.Definitions["agent"].Properties["port"].OneOf {
+		if alt.Type == "string" {
+			pattern = alt.Pattern
+		}
+	}
+	re, err := regexp.Compile(pattern)
+	if pattern == "" || err != nil {
+		t.Fatalf("got port pattern %q (%v), want a valid regular expression", pattern, err)
+	}
+
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"${PORT}", true},
+		{"${PORT:-9001}", true},
+		{"9001", false},
+		{"${PORT:-007}", false},
+		{"${PORT:-}", false},
+		{"${PORT}1", false},
+		{"${1PORT}", false},
+	}
+
+	for _, tc := range tests {
+		if got := re.MatchString(tc.value); got != tc.want {
+			t.Errorf("port %q: got match %v, want %v", tc.value, got, tc.want)
+		}
+	}
+}
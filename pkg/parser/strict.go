@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// strictType describes one of the types that YAML data is decoded into.
+type strictType struct {
+	desc string
+	t    reflect.Type
+}
+
+// strictTypes maps the type names used in yaml.v2's unknown field errors
+// to a description of the type, so that errors can name it and suggest
+// its known fields.
+var strictTypes = map[string]strictType{}
+
+func init() {
+	for desc, v := range map[string]interface{}{
+		"top level":              PeridotReq{},
+		"agent":                  PeridotAgent{},
+		"job set template":       PeridotJobSetTemplate{},
+		"step":                   PeridotJSTStep{},
+		"step group":             PeridotStepGroup{},
+		"overlay":                PeridotOverlay{},
+		"job set template patch": PeridotJobSetTemplatePatch{},
+		"insertSteps entry":      PeridotStepInsert{},
+	} {
+		t := reflect.TypeOf(v)
+		strictTypes[t.String()] = strictType{desc: desc, t: t}
+	}
+}
+
+var unknownFieldPattern = regexp.MustCompile(`^(?:line (\d+): )?field (\S+) not found in type (\S+)$`)
+
+// linePattern matches the line numbers at the start of yaml.v2's error
+// messages.
+var linePattern = regexp.MustCompile(`^line \d+: `)
+
+// unmarshal decodes YAML data from source into out. If strict is
+// true, any unknown fields are reported as errors, suggesting a known
+// field with a similar name. Errors give line numbers only if exact is
+// true, meaning that the lines in data match the lines in source.
+func unmarshal(source string, data []byte, out interface{}, strict bool, exact bool) error {
+	var err error
+	if strict {
+		err = yaml.UnmarshalStrict(data, out)
+	} else {
+		err = yaml.Unmarshal(data, out)
+	}
+	if err == nil {
+		return nil
+	}
+
+	te, ok := err.(*yaml.TypeError)
+	if !ok {
+		return fmt.Errorf("%s: %v", source, err)
+	}
+
+	msgs := []string{}
+	for _, e := range te.Errors {
+		msgs = append(msgs, describeYAMLError(source, e, exact))
+	}
+	return fmt.Errorf("%s", strings.Join(msgs, "\n"))
+}
+
+// checkUnknownFields looks for unknown fields in data, which is a YAML
+// or JSON file as it was read, by decoding it into a new value of the
+// same type as out. Other problems, such as syntax errors or values of
+// the wrong type, are left to be reported when the file is decoded
+// after any variables are filled in.
+func checkUnknownFields(source string, data []byte, out interface{}) error {
+	v := reflect.New(reflect.TypeOf(out).Elem()).Interface()
+	te, ok := yaml.UnmarshalStrict(data, v).(*yaml.TypeError)
+	if !ok {
+		return nil
+	}
+
+	msgs := []string{}
+	for _, e := range te.Errors {
+		if unknownFieldPattern.MatchString(e) {
+			msgs = append(msgs, describeYAMLError(source, e, true))
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s", strings.Join(msgs, "\n"))
+}
+
+// describeYAMLError turns an error message from yaml.v2 into one that
+// names source and, for unknown fields, suggests a known field. If
+// exact is false, line numbers are left out, since they would refer to
+// converted data rather than the file.
+func describeYAMLError(source string, e string, exact bool) string {
+	if !exact {
+		e = linePattern.ReplaceAllString(e, "")
+	}
+
+	m := unknownFieldPattern.FindStringSubmatch(e)
+	if m == nil {
+		return fmt.Sprintf("%s: %s", source, e)
+	}
+	line, field, typeName := m[1], m[2], m[3]
+
+	where := source
+	if line != "" {
+		where += ":" + line
+	}
+
+	st, ok := strictTypes[typeName]
+	if !ok {
+		return fmt.Sprintf("%s: unknown field %q", where, field)
+	}
+
+	msg := fmt.Sprintf("%s: unknown field %q in %s", where, field, st.desc)
+	if suggestion := closestField(field, yamlFieldNames(st.t)); suggestion != "" {
+		msg += fmt.Sprintf("; did you mean %q?", suggestion)
+	}
+	return msg
+}
+
+// yamlFieldNames returns the names that yaml.v2 uses for the fields of
+// the struct type t.
+func yamlFieldNames(t reflect.Type) []string {
+	names := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// closestField returns the name in names that is most similar to field,
+// or "" if none is close enough to be a likely misspelling.
+func closestField(field string, names []string) string {
+	limit := len(field)/3 + 1
+	if limit > 3 {
+		limit = 3
+	}
+
+	best, bestDist := "", limit+1
+	for _, name := range names {
+		if strings.EqualFold(field, name) {
+			return name
+		}
+		if d := editDistance(strings.ToLower(field), strings.ToLower(name)); d < bestDist {
+			best, bestDist = name, d
+		}
+	}
+
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
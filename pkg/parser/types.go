@@ -6,8 +6,11 @@ package parser
 // peridotctl objects.
 type PeridotReq struct {
 	APIVersion string                  `yaml:"apiVersion"`
+	Include    []string                `yaml:",omitempty"`
+	Overlays   []string                `yaml:",omitempty"`
 	Agents     []PeridotAgent          `yaml:",omitempty"`
 	Templates  []PeridotJobSetTemplate `yaml:"jobSetTemplates,omitempty"`
+	StepGroups []PeridotStepGroup      `yaml:"stepGroups,omitempty"`
 }
 
 // PeridotAgent represents the parsed YAML data for a peridotctl
@@ -30,7 +33,14 @@ type PeridotJobSetTemplate struct {
 // PeridotJSTStep represents the parsed YAML data for a single step in
 // a peridotctl JobSetTemplate object.
 type PeridotJSTStep struct {
-	TypeStr string `yaml:"type"`
-	Name    string
-	Steps   []PeridotJSTStep
+	TypeStr string           `yaml:"type"`
+	Name    string           `yaml:",omitempty"`
+	Steps   []PeridotJSTStep `yaml:",omitempty"`
+}
+
+// PeridotStepGroup represents the parsed YAML data for a named, reusable
+// sequence of steps, which JobSetTemplate steps of type "group" refer to.
+type PeridotStepGroup struct {
+	Name  string
+	Steps []PeridotJSTStep
 }
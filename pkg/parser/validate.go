@@ -8,7 +8,7 @@ import "fmt"
 // It returns the first found or nil if request is okay.
 func ValidateReq(req *PeridotReq) error {
 	// check the API version is valid
-	if req.APIVersion != "v0-alpha1" {
+	if req.APIVersion != CurrentAPIVersion {
 		return fmt.Errorf("unknown apiVersion: %s", req.APIVersion)
 	}
 
@@ -67,6 +67,11 @@ func ValidateJobSetTemplates(templates []PeridotJobSetTemplate) error {
 
 	}
 
+	// check that templates don't reference each other in a cycle
+	if _, err := OrderJobSetTemplates(templates); err != nil {
+		return err
+	}
+
 	// looks good!
 	return nil
 }
@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// varPattern matches $${...} escapes, ${VAR} references and
+// ${VAR:-default} references with a default value.
+var varPattern = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// SubstituteVars replaces ${VAR} and ${VAR:-default} references in the
+// string values and keys of a decoded document, such as a yaml.MapSlice,
+// and returns the result. Since it works on decoded values, references
+// in comments are ignored, and values are never read as YAML syntax.
+//
+// Values are looked up first in vars, then in the environment, and
+// finally fall back to the default if one is given. A literal "${" can
+// be written as "$${". Values are always substituted as strings; see
+// coercePorts for how references are used for ports. It returns an
+// error listing every variable that could not be resolved.
+func SubstituteVars(doc interface{}, vars map[string]string) (interface{}, error) {
+	unresolved := map[string]bool{}
+	out := substituteValue(doc, vars, unresolved)
+
+	if len(unresolved) > 0 {
+		names := []string{}
+		for name := range unresolved {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unresolved variables: %s", strings.Join(names, ", "))
+	}
+
+	return out, nil
+}
+
+func substituteValue(v interface{}, vars map[string]string, unresolved map[string]bool) interface{} {
+	switch x := v.(type) {
+	case string:
+		return substituteString(x, vars, unresolved)
+	case yaml.MapSlice:
+		out := yaml.MapSlice{}
+		for _, item := range x {
+			out = append(out, yaml.MapItem{
+				Key:   substituteValue(item.Key, vars, unresolved),
+				Value: substituteValue(item.Value, vars, unresolved),
+			})
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := map[interface{}]interface{}{}
+		for k, val := range x {
+			out[substituteValue(k, vars, unresolved)] = substituteValue(val, vars, unresolved)
+		}
+		return out
+	case []interface{}:
+		out := []interface{}{}
+		for _, val := range x {
+			out = append(out, substituteValue(val, vars, unresolved))
+		}
+		return out
+	}
+	return v
+}
+
+func substituteString(s string, vars map[string]string, unresolved map[string]bool) string {
+	return varPattern.ReplaceAllStringFunc(s, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+
+		sub := varPattern.FindStringSubmatch(match)
+		name := sub[1]
+		if v, ok := vars[name]; ok {
+			return v
+		}
+		if v, ok := os.LookupEnv(name); ok {
+			return v
+		}
+		if sub[2] != "" {
+			return sub[3]
+		}
+
+		unresolved[name] = true
+		return match
+	})
+}
+
+// coercePorts converts agents' port values in doc that are strings
+// holding a whole number, such as SubstituteVars leaves for "${PORT}",
+// into that number. Only strings written exactly as the number would
+// be are converted, so "007" and "+1" are left as they are and then
+// rejected as ports.
+func coercePorts(doc yaml.MapSlice) {
+	for _, item := range doc {
+		if item.Key != "agents" {
+			continue
+		}
+		agents, _ := item.Value.([]interface{})
+		for _, a := range agents {
+			agent, _ := a.(yaml.MapSlice)
+			for i := range agent {
+				if agent[i].Key != "port" {
+					continue
+				}
+				s, ok := agent[i].Value.(string)
+				if !ok {
+					continue
+				}
+				if n, err := strconv.ParseUint(s, 10, 32); err == nil && strconv.FormatUint(n, 10) == s {
+					agent[i].Value = n
+				}
+			}
+		}
+	}
+}
+
+// LoadValuesFile reads a YAML file containing a flat mapping of variable
+// names to values, for use with SubstituteVars.
+func LoadValuesFile(filePath string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := map[string]string{}
+	err = yaml.Unmarshal(data, &vars)
+	if err != nil {
+		return nil, fmt.Errorf("invalid values file %s: %v", filePath, err)
+	}
+
+	return vars, nil
+}
+
+// ParseSetValues parses a list of key=value strings into a variable
+// mapping. If the same key appears more than once, the latter value wins.
+func ParseSetValues(sets []string) (map[string]string, error) {
+	vars := map[string]string{}
+
+	for _, s := range sets {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid value %q, expected key=value", s)
+		}
+		vars[kv[0]] = kv[1]
+	}
+
+	return vars, nil
+}
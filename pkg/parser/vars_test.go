@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestSubstituteVars(t *testing.T) {
+	t.Setenv("PERIDOT_TEST_ENV", "from-env")
+	t.Setenv("PERIDOT_TEST_BOTH", "from-env")
+
+	vars := map[string]string{
+		"NAME":              "scanner",
+		"PORT":              "9001",
+		"PERIDOT_TEST_BOTH": "from-vars",
+		"EMPTY":             "",
+		"YAML":              "[a, b]",
+		"ID":                "007",
+		"SIGNED":            "+1",
+	}
+
+	tests := []struct {
+		name string
+		in   interface{}
+		want interface{}
+	}{
+		{"plain string", "no references", "no references"},
+		{"var", "${NAME}", "scanner"},
+		{"var in text", "id-${NAME}-1", "id-scanner-1"},
+		{"env", "${PERIDOT_TEST_ENV}", "from-env"},
+		{"vars before env", "${PERIDOT_TEST_BOTH}", "from-vars"},
+		{"default", "${MISSING:-fallback}", "fallback"},
+		{"empty default", "${MISSING:-}", ""},
+		{"set to empty beats default", "${EMPTY:-fallback}", ""},
+		{"escape", "$${NAME}", "${NAME}"},
+		{"whole number", "${PORT}", "9001"},
+		{"leading zeros", "${ID}", "007"},
+		{"leading sign", "${SIGNED}", "+1"},
+		{"number in text", "port ${PORT}", "port 9001"},
+		{"value is not YAML", "${YAML}", "[a, b]"},
+		{"non-string", 42, 42},
+		{"list", []interface{}{"${NAME}", true}, []interface{}{"scanner", true}},
+		{"map slice keys and values",
+			yaml.MapSlice{{Key: "${NAME}", Value: "${PORT}"}},
+			yaml.MapSlice{{Key: "scanner", Value: "9001"}}},
+		{"map",
+			map[interface{}]interface{}{"k": "${NAME}"},
+			map[interface{}]interface{}{"k": "scanner"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := SubstituteVars(tc.in, vars)
+			if err != nil {
+				t.Fatalf("SubstituteVars: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSubstituteVarsUnresolved(t *testing.T) {
+	doc := yaml.MapSlice{
+		{Key: "a", Value: "${ZZZ_MISSING}"},
+		{Key: "b", Value: []interface{}{"${AAA_MISSING} ${ZZZ_MISSING}"}},
+	}
+
+	_, err := SubstituteVars(doc, nil)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if want := "unresolved variables: AAA_MISSING, ZZZ_MISSING"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err, want)
+	}
+}
+
+func TestCoercePorts(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want interface{}
+	}{
+		{"9001", uint64(9001)},
+		{9001, 9001},
+		{"007", "007"},
+		{"+1", "+1"},
+		{"-1", "-1"},
+		{"4294967296", "4294967296"},
+		{"port", "port"},
+	}
+
+	for _, tc := range tests {
+		t.Run(fmt.Sprint(tc.in), func(t *testing.T) {
+			doc := yaml.MapSlice{
+				{Key: "agents", Value: []interface{}{
+					yaml.MapSlice{{Key: "name", Value: "9001"}, {Key: "port", Value: tc.in}},
+				}},
+			}
+			coercePorts(doc)
+
+			agent := doc[0].Value.([]interface{})[0].(yaml.MapSlice)
+			if got := agent[1].Value; !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got port %#v, want %#v", got, tc.want)
+			}
+			if got := agent[0].Value; got != "9001" {
+				t.Errorf("got name %#v, want it left as a string", got)
+			}
+		})
+	}
+}
+
+func TestParseSetValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		sets    []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"none", nil, map[string]string{}, false},
+		{"one", []string{"A=1"}, map[string]string{"A": "1"}, false},
+		{"later wins", []string{"A=1", "A=2"}, map[string]string{"A": "2"}, false},
+		{"equals in value", []string{"A=x=y"}, map[string]string{"A": "x=y"}, false},
+		{"empty value", []string{"A="}, map[string]string{"A": ""}, false},
+		{"no equals", []string{"A"}, nil, true},
+		{"no key", []string{"=1"}, nil, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseSetValues(tc.sets)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSetValues: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadValuesFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"values.yaml": "NAME: scanner\nPORT: 9001\n",
+		"bad.yaml":    "- not\n- a map\n",
+	})
+
+	got, err := LoadValuesFile(dir + "/values.yaml")
+	if err != nil {
+		t.Fatalf("LoadValuesFile: %v", err)
+	}
+	if want := map[string]string{"NAME": "scanner", "PORT": "9001"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := LoadValuesFile(dir + "/bad.yaml"); err == nil || !strings.Contains(err.Error(), "invalid values file") {
+		t.Errorf("got error %v, want invalid values file", err)
+	}
+}
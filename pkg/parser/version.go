@@ -0,0 +1,167 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// CurrentAPIVersion is the apiVersion that requests are converted to
+// before being unmarshalled into a PeridotReq.
+const CurrentAPIVersion = "v0-alpha1"
+
+// apiVersion describes one version of the YAML file format.
+type apiVersion struct {
+	name string
+	// deprecated is true if a warning should be given when a file
+	// with this version is parsed.
+	deprecated bool
+	// upgrade converts a document in this version to the next
+	// version. It is nil for CurrentAPIVersion.
+	upgrade func(doc yaml.MapSlice) (yaml.MapSlice, error)
+}
+
+// apiVersions lists every known apiVersion, oldest first. Each entry's
+// upgrade function converts to the entry following it.
+//
+// When the format changes, add the new version at the end with an
+// upgrade function on the entry before it, which rewrites apiVersion
+// along with whatever else changed.
+var apiVersions = []apiVersion{
+	{name: "v0-alpha1"},
+}
+
+// APIVersions returns the names of all known apiVersions, oldest first.
+func APIVersions() []string {
+	names := []string{}
+	for _, v := range apiVersions {
+		names = append(names, v.name)
+	}
+	return names
+}
+
+func findAPIVersion(name string) (int, error) {
+	for i, v := range apiVersions {
+		if v.name == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("unknown apiVersion: %s", name)
+}
+
+// ConvertDocument converts YAML data to the requested apiVersion by
+// applying each registered converter in turn. Converting to an older
+// version is not supported. It returns the converted data and the
+// apiVersion that the data was originally in.
+func ConvertDocument(data []byte, toVersion string) ([]byte, string, error) {
+	doc := yaml.MapSlice{}
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, "", err
+	}
+
+	out, fromVersion, err := convertDocument(doc, toVersion)
+	if err != nil {
+		return nil, fromVersion, err
+	}
+	if fromVersion == toVersion {
+		return data, fromVersion, nil
+	}
+
+	outData, err := yaml.Marshal(out)
+	if err != nil {
+		return nil, fromVersion, err
+	}
+	return outData, fromVersion, nil
+}
+
+// convertDocument is like ConvertDocument, but works on a decoded
+// document.
+func convertDocument(doc yaml.MapSlice, toVersion string) (yaml.MapSlice, string, error) {
+	fromVersion := ""
+	for _, item := range doc {
+		if item.Key == "apiVersion" {
+			fromVersion, _ = item.Value.(string)
+		}
+	}
+
+	from, err := findAPIVersion(fromVersion)
+	if err != nil {
+		return nil, fromVersion, err
+	}
+	to, err := findAPIVersion(toVersion)
+	if err != nil {
+		return nil, fromVersion, err
+	}
+	if to < from {
+		return nil, fromVersion, fmt.Errorf("cannot convert from apiVersion %s to older apiVersion %s", fromVersion, toVersion)
+	}
+
+	for i := from; i < to; i++ {
+		doc, err = apiVersions[i].upgrade(doc)
+		if err != nil {
+			return nil, fromVersion, fmt.Errorf("error converting from apiVersion %s: %v", apiVersions[i].name, err)
+		}
+	}
+
+	return doc, fromVersion, nil
+}
+
+// ConvertFile rewrites a request file in place so that it uses the
+// requested apiVersion. YAML, JSON and TOML files are all supported,
+// and are written back in the same format. Variable references are
+// left as they are, but comments and formatting are not preserved. It
+// returns the apiVersion that the file was originally in.
+func ConvertFile(filePath string, toVersion string) (string, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	format := DetectFormat(filePath, data)
+	doc, err := decodeDocument(format, data)
+	if err != nil {
+		return "", err
+	}
+
+	doc, fromVersion, err := convertDocument(doc, toVersion)
+	if err != nil {
+		return fromVersion, err
+	}
+	if fromVersion == toVersion {
+		return fromVersion, nil
+	}
+
+	out, err := encodeDocument(format, doc)
+	if err != nil {
+		return fromVersion, err
+	}
+
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return fromVersion, err
+	}
+	return fromVersion, ioutil.WriteFile(filePath, out, fi.Mode())
+}
+
+// upgradeToCurrent converts a decoded document from a file to
+// CurrentAPIVersion, calling warn if the file's apiVersion is
+// deprecated. It returns the converted document and the apiVersion it
+// was converted from.
+func upgradeToCurrent(filePath string, doc yaml.MapSlice, warn func(string)) (yaml.MapSlice, string, error) {
+	out, fromVersion, err := convertDocument(doc, CurrentAPIVersion)
+	if err != nil {
+		return nil, fromVersion, fmt.Errorf("%s: %v", filePath, err)
+	}
+
+	i, _ := findAPIVersion(fromVersion)
+	if apiVersions[i].deprecated && warn != nil {
+		warn(fmt.Sprintf("%s: apiVersion %s is deprecated; update it to %s", filePath, fromVersion, CurrentAPIVersion))
+	}
+
+	return out, fromVersion, nil
+}
@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package parser
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// withTestVersion registers an extra apiVersion after the current one
+// for the rest of the test, whose upgrade renames agents' "url" field
+// to "host".
+func withTestVersion(t *testing.T) {
+	saved := apiVersions
+	t.Cleanup(func() { apiVersions = saved })
+
+	apiVersions = append([]apiVersion{}, saved...)
+	last := &apiVersions[len(apiVersions)-1]
+	last.deprecated = true
+	last.upgrade = func(doc yaml.MapSlice) (yaml.MapSlice, error) {
+		for i := range doc {
+			switch doc[i].Key {
+			case "apiVersion":
+				doc[i].Value = "test-next"
+			case "agents":
+				agents, _ := doc[i].Value.([]interface{})
+				for _, a := range agents {
+					agent, _ := a.(yaml.MapSlice)
+					for j := range agent {
+						if agent[j].Key == "url" {
+							agent[j].Key = "host"
+						}
+					}
+				}
+			}
+		}
+		return doc, nil
+	}
+	apiVersions = append(apiVersions, apiVersion{name: "test-next"})
+}
+
+func TestConvertFile(t *testing.T) {
+	withTestVersion(t)
+
+	tests := []struct {
+		name string
+		file string
+		data string
+		want []string
+	}{
+		{"YAML", "m.yaml", formatTestYAML, []string{"apiVersion: test-next", "host: localhost", "${MODE:-fast}"}},
+		{"JSON", "m.json", formatTestJSON, []string{`"apiVersion": "test-next"`, `"host": "localhost"`}},
+		{"TOML", "m.toml", formatTestTOML, []string{`apiVersion = "test-next"`, `host = "localhost"`}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tc.file)
+			if err := ioutil.WriteFile(path, []byte(tc.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			from, err := ConvertFile(path, "test-next")
+			if err != nil {
+				t.Fatalf("ConvertFile: %v", err)
+			}
+			if from != "v0-alpha1" {
+				t.Errorf("converted from %s, want v0-alpha1", from)
+			}
+
+			out, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := DetectFormat(path, out)
+			if got := DetectFormat("", out); got != want {
+				t.Errorf("converted file looks like %s, want %s:\n%s", got, want, out)
+			}
+			for _, w := range tc.want {
+				if !strings.Contains(string(out), w) {
+					t.Errorf("converted file missing %q:\n%s", w, out)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertFileAlreadyCurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "m.yaml")
+	data := "# comment kept\n" + formatTestYAML
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	from, err := ConvertFile(path, CurrentAPIVersion)
+	if err != nil {
+		t.Fatalf("ConvertFile: %v", err)
+	}
+	if from != CurrentAPIVersion {
+		t.Errorf("converted from %s, want %s", from, CurrentAPIVersion)
+	}
+	out, _ := ioutil.ReadFile(path)
+	if string(out) != data {
+		t.Errorf("file was rewritten:\n%s", out)
+	}
+}
+
+func TestConvertDocumentErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		to   string
+	}{
+		{"unknown from", "apiVersion: v9\n", CurrentAPIVersion},
+		{"missing apiVersion", "agents: []\n", CurrentAPIVersion},
+		{"unknown to", "apiVersion: v0-alpha1\n", "v9"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, _, err := ConvertDocument([]byte(tc.data), tc.to); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
+
+func TestConvertDocumentToOlder(t *testing.T) {
+	withTestVersion(t)
+
+	if _, _, err := ConvertDocument([]byte("apiVersion: test-next\n"), "v0-alpha1"); err == nil {
+		t.Errorf("expected error converting to older version")
+	}
+}
+
+func TestUpgradeToCurrentWarnsWhenDeprecated(t *testing.T) {
+	withTestVersion(t)
+
+	doc := yaml.MapSlice{{Key: "apiVersion", Value: "v0-alpha1"}}
+	warnings := []string{}
+	_, from, err := upgradeToCurrent("m.yaml", doc, func(msg string) { warnings = append(warnings, msg) })
+	if err != nil {
+		t.Fatalf("upgradeToCurrent: %v", err)
+	}
+	if from != "v0-alpha1" {
+		t.Errorf("converted from %s, want v0-alpha1", from)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "deprecated") {
+		t.Errorf("got warnings %q, want one deprecation warning", warnings)
+	}
+}
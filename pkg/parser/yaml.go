@@ -0,0 +1,160 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ParseOptions contains the settings for parsing a request file.
+type ParseOptions struct {
+	// Vars are values for ${VAR} references, which take priority
+	// over environment variables.
+	Vars map[string]string
+	// Overlays are paths to overlay files, which are applied after
+	// any overlays listed in the file itself.
+	Overlays []string
+	// Warn, if set, is called with any warnings about the files, such
+	// as use of a deprecated apiVersion.
+	Warn func(string)
+	// Lenient, if true, ignores unknown fields rather than treating
+	// them as errors.
+	Lenient bool
+}
+
+// ParseYAML takes a file path and tries to parse it as a set of
+// peridotctl instructions, written in YAML, JSON or TOML. Any ${VAR}
+// references are substituted from opts.Vars or the environment, and
+// older apiVersions are converted, before parsing. Included files and
+// overlays are then merged in, and step groups are expanded, before
+// validating. It returns the parsed PeridotReq object, or an error if
+// unable to load or if the contents are invalid.
+func ParseYAML(filePath string, opts ParseOptions) (*PeridotReq, error) {
+	// read in the YAML file and any files it includes, filling in
+	// any variables
+	loader := &includeLoader{opts: opts, loaded: map[string]bool{}}
+	req, err := loader.load(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// merge in overlays; ones listed in the file are relative to it
+	overlays := []string{}
+	for _, o := range req.Overlays {
+		if !filepath.IsAbs(o) {
+			o = filepath.Join(filepath.Dir(filePath), o)
+		}
+		overlays = append(overlays, o)
+	}
+	overlays = append(overlays, opts.Overlays...)
+	req.Overlays = nil
+
+	for _, o := range overlays {
+		err = mergeOverlayFile(req, o, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// replace any references to step groups with their steps
+	err = ExpandStepGroups(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// now, inspect the request object and its subparts to confirm
+	// they are valid
+	err = ValidateReq(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// request is loaded and valid! return it
+	return req, nil
+}
+
+// RenderYAML converts a parsed request back into YAML.
+func RenderYAML(req *PeridotReq) ([]byte, error) {
+	return yaml.Marshal(req)
+}
+
+// readFile reads a request file, fills in any variables, converts it
+// to CurrentAPIVersion and decodes it into out. Unless opts.Lenient is
+// set, unknown fields are reported as errors.
+func readFile(filePath string, opts ParseOptions, out interface{}) error {
+	raw, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	format := DetectFormat(filePath, raw)
+	doc, err := decodeDocument(format, raw)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filePath, err)
+	}
+
+	substituted, err := SubstituteVars(doc, opts.Vars)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filePath, err)
+	}
+
+	doc, fromVersion, err := upgradeToCurrent(filePath, substituted.(yaml.MapSlice), opts.Warn)
+	if err != nil {
+		return err
+	}
+	coercePorts(doc)
+
+	// line numbers can only refer to the file if it was YAML or JSON
+	// in CurrentAPIVersion to begin with
+	source := filePath
+	asWritten := true
+	switch {
+	case format == FormatTOML:
+		source = fmt.Sprintf("%s (converted from TOML)", filePath)
+		asWritten = false
+	case fromVersion != CurrentAPIVersion:
+		source = fmt.Sprintf("%s (converted to %s)", filePath, CurrentAPIVersion)
+		asWritten = false
+	}
+
+	// where possible, look for unknown fields in the file as written,
+	// so that errors point at the right lines
+	strict := !opts.Lenient
+	if strict && asWritten {
+		if err := checkUnknownFields(filePath, raw, out); err != nil {
+			return err
+		}
+		strict = false
+	}
+
+	// if nothing needed filling in, decode the file as written too, so
+	// that any other errors also point at the right lines
+	if asWritten && !varPattern.Match(raw) {
+		return unmarshal(source, raw, out, strict, true)
+	}
+
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filePath, err)
+	}
+	return unmarshal(source, data, out, strict, false)
+}
+
+func mergeOverlayFile(req *PeridotReq, filePath string, opts ParseOptions) error {
+	overlay := PeridotOverlay{}
+	err := readFile(filePath, opts, &overlay)
+	if err != nil {
+		return err
+	}
+
+	err = MergeOverlay(req, &overlay)
+	if err != nil {
+		return fmt.Errorf("overlay %s: %v", filePath, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,448 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package parser
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes each named file into a new temporary directory and
+// returns the directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+const parseTestBase = `apiVersion: v0-alpha1
+agents:
+- name: idsearcher
+  url: localhost
+  port: 9001
+  type: idsearcher
+  configs:
+    mode: fast
+jobSetTemplates:
+- name: scan
+  steps:
+  - type: agent
+    name: idsearcher
+`
+
+func TestParseYAMLFormatsAgree(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"m.yaml": formatTestYAML,
+		"m.json": `{
+  "apiVersion": "v0-alpha1",
+  "agents": [{"name": "idsearcher", "url": "localhost", "port": 9001, "type": "idsearcher",
+    "configs": {"mode": "${MODE:-fast}"}}],
+  "jobSetTemplates": [{"name": "scan", "steps": [
+    {"type": "agent", "name": "idsearcher"},
+    {"type": "concurrent", "steps": [{"type": "agent", "name": "idsearcher"}]}
+  ]}]
+}`,
+		"m.toml": formatTestTOML + `
+[agents.configs]
+mode = "${MODE:-fast}"
+
+[[jobSetTemplates]]
+name = "scan"
+
+[[jobSetTemplates.steps]]
+type = "agent"
+name = "idsearcher"
+
+[[jobSetTemplates.steps]]
+type = "concurrent"
+
+[[jobSetTemplates.steps.steps]]
+type = "agent"
+name = "idsearcher"
+`,
+	})
+
+	want, err := ParseYAML(filepath.Join(dir, "m.yaml"), ParseOptions{})
+	if err != nil {
+		t.Fatalf("YAML: %v", err)
+	}
+	if want.Agents[0].Configs["mode"] != "fast" {
+		t.Errorf("got mode %q, want default fast", want.Agents[0].Configs["mode"])
+	}
+
+	for _, name := range []string{"m.json", "m.toml"} {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseYAML(filepath.Join(dir, name), ParseOptions{})
+			if err != nil {
+				t.Fatalf("ParseYAML: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLVars(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"m.yaml": `apiVersion: v0-alpha1
+# ${UNDEFINED} in a comment is ignored
+agents:
+- name: ${NAME}
+  url: localhost
+  port: ${PORT}
+  type: idsearcher
+`,
+	})
+
+	req, err := ParseYAML(filepath.Join(dir, "m.yaml"), ParseOptions{
+		Vars: map[string]string{"NAME": "scanner", "PORT": "9002"},
+	})
+	if err != nil {
+		t.Fatalf("ParseYAML: %v", err)
+	}
+	if a := req.Agents[0]; a.Name != "scanner" || a.Port != 9002 {
+		t.Errorf("got agent %+v, want name scanner and port 9002", a)
+	}
+
+	// names are never turned into numbers
+	req, err = ParseYAML(filepath.Join(dir, "m.yaml"), ParseOptions{
+		Vars: map[string]string{"NAME": "007", "PORT": "9002"},
+	})
+	if err != nil {
+		t.Fatalf("ParseYAML with numeric name: %v", err)
+	}
+	if a := req.Agents[0]; a.Name != "007" {
+		t.Errorf("got agent name %q, want 007", a.Name)
+	}
+
+	// ports must be written as plain numbers
+	for _, port := range []string{"007", "+1"} {
+		_, err = ParseYAML(filepath.Join(dir, "m.yaml"), ParseOptions{
+			Vars: map[string]string{"NAME": "scanner", "PORT": port},
+		})
+		if err == nil {
+			t.Errorf("got no error for port %q", port)
+		}
+	}
+
+	_, err = ParseYAML(filepath.Join(dir, "m.yaml"), ParseOptions{})
+	if err == nil || !strings.HasSuffix(err.Error(), "unresolved variables: NAME, PORT") {
+		t.Errorf("got error %v, want unresolved NAME and PORT", err)
+	}
+}
+
+func TestParseYAMLOverlays(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"base.yaml": parseTestBase,
+		"listed.yaml": parseTestBase + `overlays:
+- port.yaml
+`,
+		"port.yaml": `apiVersion: v0-alpha1
+agents:
+- name: idsearcher
+  port: 9100
+  configs:
+    depth: "2"
+- name: tagger
+  url: localhost
+  port: 9002
+  type: tagger
+`,
+		"steps.yaml": `apiVersion: v0-alpha1
+jobSetTemplates:
+- name: scan
+  insertSteps:
+  - at: 0
+    steps:
+    - type: agent
+      name: tagger
+  - steps:
+    - type: jobset
+      name: other
+- name: other
+  steps:
+  - type: agent
+    name: tagger
+`,
+		"badpos.yaml": `apiVersion: v0-alpha1
+jobSetTemplates:
+- name: scan
+  insertSteps:
+  - at: 5
+    steps:
+    - type: agent
+      name: tagger
+`,
+	})
+
+	wantAgents := []PeridotAgent{
+		{Name: "idsearcher", URL: "localhost", Port: 9100, TypeStr: "idsearcher",
+			Configs: map[string]string{"mode": "fast", "depth": "2"}},
+		{Name: "tagger", URL: "localhost", Port: 9002, TypeStr: "tagger"},
+	}
+
+	tests := []struct {
+		name     string
+		file     string
+		overlays []string
+	}{
+		{"listed in file", "listed.yaml", nil},
+		{"from options", "base.yaml", []string{filepath.Join(dir, "port.yaml")}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := ParseYAML(filepath.Join(dir, tc.file), ParseOptions{Overlays: tc.overlays})
+			if err != nil {
+				t.Fatalf("ParseYAML: %v", err)
+			}
+			if !reflect.DeepEqual(req.Agents, wantAgents) {
+				t.Errorf("got agents %+v, want %+v", req.Agents, wantAgents)
+			}
+			if req.Overlays != nil {
+				t.Errorf("got overlays %v, want none left", req.Overlays)
+			}
+		})
+	}
+
+	t.Run("insert steps", func(t *testing.T) {
+		req, err := ParseYAML(filepath.Join(dir, "base.yaml"), ParseOptions{
+			Overlays: []string{filepath.Join(dir, "port.yaml"), filepath.Join(dir, "steps.yaml")},
+		})
+		if err != nil {
+			t.Fatalf("ParseYAML: %v", err)
+		}
+		want := []PeridotJSTStep{agentStep("tagger"), agentStep("idsearcher"), jobSetStep("other")}
+		if !reflect.DeepEqual(req.Templates[0].Steps, want) {
+			t.Errorf("got steps %+v, want %+v", req.Templates[0].Steps, want)
+		}
+		if names := templateNames(req.Templates); !reflect.DeepEqual(names, []string{"scan", "other"}) {
+			t.Errorf("got templates %v, want scan and other", names)
+		}
+	})
+
+	t.Run("invalid position", func(t *testing.T) {
+		_, err := ParseYAML(filepath.Join(dir, "base.yaml"), ParseOptions{
+			Overlays: []string{filepath.Join(dir, "badpos.yaml")},
+		})
+		if err == nil || !strings.Contains(err.Error(), "invalid insertSteps position 5 for template scan with 1 steps") {
+			t.Errorf("got error %v, want invalid insertSteps position", err)
+		}
+	})
+}
+
+func TestParseYAMLIncludes(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.yaml": `apiVersion: v0-alpha1
+include:
+- agents.yaml
+- templates.yaml
+jobSetTemplates:
+- name: full
+  steps:
+  - type: jobset
+    name: scan
+`,
+		"agents.yaml": `apiVersion: v0-alpha1
+agents:
+- name: idsearcher
+  url: localhost
+  port: 9001
+  type: idsearcher
+`,
+		"templates.yaml": `apiVersion: v0-alpha1
+include:
+- agents.yaml
+jobSetTemplates:
+- name: scan
+  steps:
+  - type: agent
+    name: idsearcher
+`,
+		"a.yaml": `apiVersion: v0-alpha1
+include:
+- b.yaml
+`,
+		"b.yaml": `apiVersion: v0-alpha1
+include:
+- a.yaml
+`,
+	})
+
+	req, err := ParseYAML(filepath.Join(dir, "main.yaml"), ParseOptions{})
+	if err != nil {
+		t.Fatalf("ParseYAML: %v", err)
+	}
+	if len(req.Agents) != 1 {
+		t.Errorf("got %d agents, want agents.yaml included once", len(req.Agents))
+	}
+	if names := templateNames(req.Templates); !reflect.DeepEqual(names, []string{"scan", "full"}) {
+		t.Errorf("got templates %v, want included scan before full", names)
+	}
+
+	_, err = ParseYAML(filepath.Join(dir, "a.yaml"), ParseOptions{})
+	want := "include cycle: " + filepath.Join(dir, "a.yaml") + " -> " + filepath.Join(dir, "b.yaml") + " -> " + filepath.Join(dir, "a.yaml")
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %s", err, want)
+	}
+}
+
+func TestParseYAMLStepGroups(t *testing.T) {
+	tests := []struct {
+		name    string
+		groups  string
+		want    []PeridotJSTStep
+		wantErr string
+	}{
+		{
+			name: "expanded",
+			groups: `stepGroups:
+- name: scans
+  steps:
+  - type: agent
+    name: idsearcher
+  - type: group
+    name: tags
+- name: tags
+  steps:
+  - type: agent
+    name: tagger
+`,
+			want: []PeridotJSTStep{
+				agentStep("idsearcher"),
+				agentStep("tagger"),
+				concurrentStep(agentStep("idsearcher"), agentStep("tagger")),
+			},
+		},
+		{
+			name: "unknown group",
+			groups: `stepGroups:
+- name: scans
+  steps:
+  - type: group
+    name: nosuchgroup
+`,
+			wantErr: "template main: unknown step group nosuchgroup",
+		},
+		{
+			name: "cycle",
+			groups: `stepGroups:
+- name: scans
+  steps:
+  - type: group
+    name: tags
+- name: tags
+  steps:
+  - type: group
+    name: scans
+`,
+			wantErr: "template main: step group cycle: scans -> tags -> scans",
+		},
+		{
+			name: "duplicate group",
+			groups: `stepGroups:
+- name: scans
+  steps:
+  - type: agent
+    name: idsearcher
+- name: scans
+  steps:
+  - type: agent
+    name: tagger
+`,
+			wantErr: "got more than one step group named scans",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{
+				"m.yaml": `apiVersion: v0-alpha1
+jobSetTemplates:
+- name: main
+  steps:
+  - type: group
+    name: scans
+  - type: concurrent
+    steps:
+    - type: group
+      name: scans
+` + tc.groups,
+			})
+
+			req, err := ParseYAML(filepath.Join(dir, "m.yaml"), ParseOptions{})
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Errorf("got error %v, want %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseYAML: %v", err)
+			}
+			if !reflect.DeepEqual(req.Templates[0].Steps, tc.want) {
+				t.Errorf("got steps %+v, want %+v", req.Templates[0].Steps, tc.want)
+			}
+			if req.StepGroups != nil {
+				t.Errorf("got step groups %+v, want none left", req.StepGroups)
+			}
+		})
+	}
+}
+
+func TestParseYAMLStrict(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"m.yaml": "apiVersion: " + CurrentAPIVersion + `
+agents:
+- name: idsearcher
+  url: localhost
+  prot: 9001
+  port: 9001
+  type: idsearcher
+`,
+	})
+	path := filepath.Join(dir, "m.yaml")
+
+	_, err := ParseYAML(path, ParseOptions{})
+	want := path + `:5: unknown field "prot" in agent; did you mean "port"?`
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %s", err, want)
+	}
+
+	req, err := ParseYAML(path, ParseOptions{Lenient: true})
+	if err != nil {
+		t.Fatalf("lenient ParseYAML: %v", err)
+	}
+	if req.Agents[0].Port != 9001 {
+		t.Errorf("got port %d, want 9001", req.Agents[0].Port)
+	}
+}
+
+func TestParseYAMLStrictConverted(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"m.toml": `apiVersion = "v0-alpha1"
+
+[[agents]]
+name = "idsearcher"
+url = "localhost"
+prot = 9001
+type = "idsearcher"
+`,
+	})
+
+	// line numbers would refer to the converted data, so are left out
+	_, err := ParseYAML(filepath.Join(dir, "m.toml"), ParseOptions{})
+	want := filepath.Join(dir, "m.toml") + ` (converted from TOML): unknown field "prot" in agent; did you mean "port"?`
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %s", err, want)
+	}
+}
@@ -0,0 +1,227 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+// Package golden runs peridotctl commands against a fake peridot
+// controller and compares their output and exit codes against golden
+// files:
+//
+//	go test ./test/golden
+//
+// Use -update to rewrite the golden files after an intended change in
+// output, then review the differences before committing them:
+//
+//	go test ./test/golden -update
+//
+// The cases are listed in order in testdata/cases.yaml. They all run
+// against the same fake controller, so later cases see the agents,
+// templates and job sets created by earlier ones, and the cases must
+// be run together rather than picked out with -run.
+//
+// The test binary runs itself as peridotctl for each case, with the
+// current time fixed to the fake controller's clock.
+package golden
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	pbc "github.com/swinslow/peridot-core/pkg/controller"
+	pbs "github.com/swinslow/peridot-core/pkg/status"
+	"github.com/swinslow/peridotctl/cmd"
+	"github.com/swinslow/peridotctl/pkg/fakecontroller"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files with the current output")
+
+const dataDir = "testdata"
+
+// nowEnv is the environment variable that tells the test binary to run
+// as peridotctl, with the current time fixed to its value (RFC 3339).
+const nowEnv = "PERIDOTCTL_GOLDEN_NOW"
+
+func TestMain(m *testing.M) {
+	if s := os.Getenv(nowEnv); s != "" {
+		now, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			panic(err)
+		}
+		cmd.SetNowFunc(func() time.Time { return now })
+		cmd.Execute()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestGolden(t *testing.T) {
+	cases, err := loadCases(filepath.Join(dataDir, "cases.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpDir := t.TempDir()
+	bin, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the fake controller's clock moves forward one second each time
+	// job sets move forward, and peridotctl is told that it is the
+	// current time, so that times are the same on every run
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	srv := fakecontroller.New(fakecontroller.Options{
+		Now: clock.Now,
+	})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lis)
+	defer srv.Shutdown()
+
+	openPort, closedPort := agentPorts(t)
+
+	r := &runner{
+		bin:        bin,
+		dir:        dataDir,
+		address:    lis.Addr().String(),
+		home:       tmpDir,
+		clock:      clock,
+		openPort:   openPort,
+		closedPort: closedPort,
+	}
+
+	for _, c := range cases {
+		for i := 0; i < c.Advance; i++ {
+			clock.advance(srv)
+		}
+
+		stopTicking := func() {}
+		if c.Tick != "" {
+			interval, _ := time.ParseDuration(c.Tick)
+			stopTicking = tickWhileRunning(clock, srv, interval)
+		}
+		got, err := r.run(c)
+		stopTicking()
+		if err != nil {
+			t.Fatalf("could not run case %s: %v", c.Name, err)
+		}
+
+		goldenFile := filepath.Join(dataDir, c.Name+".golden")
+		if *update {
+			if err := ioutil.WriteFile(goldenFile, []byte(got), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		want, err := ioutil.ReadFile(goldenFile)
+		if err != nil {
+			t.Fatalf("could not read golden file for case %s (run with -update to create it): %v", c.Name, err)
+		}
+		if string(want) != got {
+			t.Errorf("case %s did not match:\n%s", c.Name, diff(string(want), got))
+		}
+	}
+}
+
+// agentPorts returns a port with a listener that accepts and closes
+// connections until the test ends, for agents that health checks can
+// reach, and a port with nothing listening, for agents they can't.
+func agentPorts(t *testing.T) (string, string) {
+	open, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { open.Close() })
+	go func() {
+		for {
+			conn, err := open.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().(*net.TCPAddr)
+	closed.Close()
+
+	return strconv.Itoa(open.Addr().(*net.TCPAddr).Port), strconv.Itoa(closedAddr.Port)
+}
+
+// fakeClock is the fake controller's clock, which only moves when job
+// sets move forward.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// advance moves the clock forward one second, and then moves job sets
+// forward.
+func (c *fakeClock) advance(srv *fakecontroller.Server) {
+	c.mu.Lock()
+	c.now = c.now.Add(time.Second)
+	c.mu.Unlock()
+	srv.Advance()
+}
+
+// tickWhileRunning moves job sets forward every interval, as long as
+// any are running, until the returned function is called. Once none
+// are running the clock stops too, so that later cases see the same
+// times however long the command took.
+func tickWhileRunning(clock *fakeClock, srv *fakecontroller.Server, interval time.Duration) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if anyRunning(srv) {
+					clock.advance(srv)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// anyRunning reports whether any of the fake controller's job sets are
+// not yet stopped.
+func anyRunning(srv *fakecontroller.Server) bool {
+	resp, err := srv.GetAllJobSets(context.Background(), &pbc.GetAllJobSetsReq{})
+	if err != nil {
+		return false
+	}
+	for _, jsd := range resp.JobSets {
+		if jsd.St.RunStatus != pbs.Status_STOPPED {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+// SPDX-License-Identifier: Apache-2.0 OR GPL-2.0-or-later
+
+package golden
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// testCase is one peridotctl command to run and check.
+type testCase struct {
+	// Name is the case's name, which is also the name of its golden
+	// file without the ".golden" extension.
+	Name string `yaml:"name"`
+	// Args are the arguments to peridotctl, not including --address.
+	Args []string `yaml:"args"`
+	// Advance is how many times to move job sets forward before
+	// running the command.
+	Advance int `yaml:"advance,omitempty"`
+	// Tick, if set, is how often to move running job sets forward
+	// while the command runs, such as "20ms".
+	Tick string `yaml:"tick,omitempty"`
+	// Stdin is given to the command as its standard input.
+	Stdin string `yaml:"stdin,omitempty"`
+}
+
+func loadCases(filePath string) ([]testCase, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var cases []testCase
+	if err := yaml.UnmarshalStrict(data, &cases); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", filePath, err)
+	}
+
+	seen := map[string]bool{}
+	for _, c := range cases {
+		if c.Name == "" || len(c.Args) == 0 {
+			return nil, fmt.Errorf("%s: each case needs a name and args", filePath)
+		}
+		if c.Tick != "" {
+			if d, err := time.ParseDuration(c.Tick); err != nil || d <= 0 {
+				return nil, fmt.Errorf("%s: invalid tick %q for case %s", filePath, c.Tick, c.Name)
+			}
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("%s: duplicate case name %s", filePath, c.Name)
+		}
+		seen[c.Name] = true
+	}
+
+	return cases, nil
+}
+
+// runner runs peridotctl commands for test cases.
+type runner struct {
+	// bin is the test binary, which runs as peridotctl when nowEnv is
+	// set.
+	bin     string
+	dir     string
+	address string
+	home    string
+	clock   *fakeClock
+	// openPort and closedPort replace {{openport}} and {{closedport}}
+	// in arguments, and are given to manifests as ${OPEN_PORT} and
+	// ${CLOSED_PORT}. They are put back in the output, since they are
+	// different on every run.
+	openPort   string
+	closedPort string
+}
+
+// logPrefix matches the date and time that the log package puts at
+// the start of each line.
+var logPrefix = regexp.MustCompile(`(?m)^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} `)
+
+// run runs the command for c, and returns its result in the format
+// used for golden files.
+func (r *runner) run(c testCase) (string, error) {
+	ports := strings.NewReplacer("{{openport}}", r.openPort, "{{closedport}}", r.closedPort)
+	args := []string{"--address", r.address}
+	for _, arg := range c.Args {
+		args = append(args, ports.Replace(arg))
+	}
+	// cobra only completes if __complete comes first, so move it in
+	// front of --address
+	if c.Args[0] == cobra.ShellCompRequestCmd {
+		args[0], args[1], args[2] = args[2], args[0], args[1]
+	}
+	cmd := exec.Command(r.bin, args...)
+	cmd.Dir = r.dir
+	// keep the user's config file, environment and cache out of it
+	cmd.Env = []string{
+		"HOME=" + r.home,
+		"XDG_CACHE_HOME=" + filepath.Join(r.home, "cache"),
+		"TZ=UTC",
+		nowEnv + "=" + r.clock.Now().Format(time.RFC3339),
+		"OPEN_PORT=" + r.openPort,
+		"CLOSED_PORT=" + r.closedPort,
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(c.Stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	exitCode := 0
+	if err := cmd.Run(); err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return "", err
+		}
+		exitCode = exitErr.ExitCode()
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "$ peridotctl %s\n", strings.Join(c.Args, " "))
+	fmt.Fprintf(&out, "exit code: %d\n", exitCode)
+	fmt.Fprintf(&out, "-- stdout --\n%s", ensureNewline(stdout.String()))
+	fmt.Fprintf(&out, "-- stderr --\n%s", ensureNewline(logPrefix.ReplaceAllString(stderr.String(), "")))
+	return r.hidePorts(out.String()), nil
+}
+
+// hidePorts replaces the agent ports in s with their placeholders.
+func (r *runner) hidePorts(s string) string {
+	s = regexp.MustCompile(`\b`+r.openPort+`\b`).ReplaceAllString(s, "{{openport}}")
+	return regexp.MustCompile(`\b`+r.closedPort+`\b`).ReplaceAllString(s, "{{closedport}}")
+}
+
+func ensureNewline(s string) string {
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		return s + "\n"
+	}
+	return s
+}
+
+// diff shows the lines that differ between want and got, with "-" for
+// lines only in want and "+" for lines only in got.
+func diff(want, got string) string {
+	wantLines := strings.Split(want, "\n")
+	gotLines := strings.Split(got, "\n")
+
+	// find the longest common subsequence of lines
+	lcs := make([][]int, len(wantLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(gotLines)+1)
+	}
+	for i := len(wantLines) - 1; i >= 0; i-- {
+		for j := len(gotLines) - 1; j >= 0; j-- {
+			if wantLines[i] == gotLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(wantLines) || j < len(gotLines) {
+		switch {
+		case i < len(wantLines) && j < len(gotLines) && wantLines[i] == gotLines[j]:
+			i++
+			j++
+		case j == len(gotLines) || (i < len(wantLines) && lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&out, "    -%s\n", wantLines[i])
+			i++
+		default:
+			fmt.Fprintf(&out, "    +%s\n", gotLines[j])
+			j++
+		}
+	}
+	return out.String()
+}